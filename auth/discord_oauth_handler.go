@@ -55,8 +55,11 @@ func (h *DiscordOAuthHandler) handleNewSession(w http.ResponseWriter, r *http.Re
 		return
 	}
 
+	// Lax so that the cookie is sent when returning from Discord,
+	// but not with requests made by other sites.
 	http.SetCookie(w, &http.Cookie{
 		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
 		Name:     "sessionID",
 		Value:    session.ID,
 	})
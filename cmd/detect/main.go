@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/xoltia/botsu-oshi-stats/index"
+	"github.com/xoltia/botsu-oshi-stats/logs"
+	"github.com/xoltia/botsu-oshi-stats/vtubers"
+)
+
+func main() {
+	log.SetFlags(0)
+
+	var (
+		videoID string
+		title   string
+		channel string
+		linked  []string
+	)
+	flag.StringVar(&videoID, "video", "", "id of an indexed video to detect vtubers in")
+	flag.StringVar(&title, "title", "", "video title, replacing that of the indexed video")
+	flag.StringVar(&channel, "channel", "", "uploader channel id, replacing that of the indexed video")
+	flag.Func("linked", "linked channel id or handle, may be repeated", func(s string) error {
+		linked = append(linked, s)
+		return nil
+	})
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: detect [-video <id>] [-title <title>] [-channel <id>] [-linked <channel>]...")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if len(set) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	db, err := sqlx.Open("sqlite3", "oshistats.db?_journal_mode=WAL")
+	if err != nil {
+		log.Panicln(err)
+	}
+	defer db.Close()
+
+	store, err := vtubers.CreateStore(ctx, db)
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	var video logs.VideoInfo
+	if videoID != "" {
+		indexRepo, err := index.CreateIndexedVideoRepository(ctx, db)
+		if err != nil {
+			log.Panicln(err)
+		}
+		video, err = indexRepo.GetVideo(ctx, videoID)
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Fatalf("Video %s is not indexed", videoID)
+		} else if err != nil {
+			log.Panicln(err)
+		}
+	}
+	if set["title"] {
+		video.Title = title
+	}
+	if set["channel"] {
+		video.ChannelID = channel
+	}
+	if set["linked"] {
+		video.LinkedChannels = linked
+	}
+
+	detector, err := vtubers.CreateDetector(ctx, store)
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	if err := detector.Explain(video).WriteText(os.Stdout); err != nil {
+		log.Panicln(err)
+	}
+}
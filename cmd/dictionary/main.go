@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/xoltia/botsu-oshi-stats/index"
+	"github.com/xoltia/botsu-oshi-stats/vtubers"
+)
+
+const usage = `usage: dictionary <command> [flags] [args]
+
+commands:
+  alias add -vtuber <id> [-filter original|english|none] <alias>...
+  alias remove -vtuber <id> <alias>...
+  alias list [-vtuber <id>]
+  rule add -vtuber <id> [-kind exclude|require] [-context title|before|after] [-name <name>] <pattern>...
+  rule remove -vtuber <id> <rule id>...
+  rule list [-vtuber <id>]
+  override add|remove|delete -video <id> -vtuber <id> [-user <id>]
+  override list [-video <id>]
+`
+
+func main() {
+	log.SetFlags(0)
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+	}
+	flag.Parse()
+	if flag.NArg() < 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	db, err := sqlx.Open("sqlite3", "oshistats.db?_journal_mode=WAL")
+	if err != nil {
+		log.Panicln(err)
+	}
+	defer db.Close()
+
+	store, err := vtubers.CreateStore(ctx, db)
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	indexRepo, err := index.CreateIndexedVideoRepository(ctx, db)
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	command, subcommand, args := flag.Arg(0), flag.Arg(1), flag.Args()[2:]
+	switch {
+	case command == "alias" && subcommand == "add":
+		err = addAliases(ctx, store, args)
+	case command == "alias" && subcommand == "remove":
+		err = removeAliases(ctx, store, args)
+	case command == "alias" && subcommand == "list":
+		err = listAliases(ctx, store, args)
+	case command == "rule" && subcommand == "add":
+		err = addRules(ctx, store, args)
+	case command == "rule" && subcommand == "remove":
+		err = removeRules(ctx, store, args)
+	case command == "rule" && subcommand == "list":
+		err = listRules(ctx, store, args)
+	case command == "override" && (subcommand == "add" || subcommand == "remove" || subcommand == "delete"):
+		err = setOverride(ctx, store, indexRepo, subcommand, args)
+	case command == "override" && subcommand == "list":
+		err = listOverrides(ctx, indexRepo, args)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Panicln(err)
+	}
+}
+
+// Parses the flags of a subcommand, requiring a vtuber which exists in the store
+// and at least one argument if required is set.
+func parseVTuberFlags(
+	ctx context.Context,
+	store *vtubers.Store,
+	fs *flag.FlagSet,
+	args []string,
+	required bool,
+) (vtubers.VTuber, error) {
+	vtuberID := fs.Int("vtuber", 0, "id of the vtuber")
+	fs.Parse(args)
+	if *vtuberID == 0 && !required {
+		return vtubers.VTuber{}, nil
+	}
+	if *vtuberID == 0 || (required && fs.NArg() == 0) {
+		fs.Usage()
+		os.Exit(2)
+	}
+	v, err := store.FindByID(ctx, *vtuberID)
+	if err != nil {
+		return v, fmt.Errorf("find vtuber %d: %w", *vtuberID, err)
+	}
+	return v, nil
+}
+
+func addAliases(ctx context.Context, store *vtubers.Store, args []string) error {
+	fs := flag.NewFlagSet("alias add", flag.ExitOnError)
+	filter := fs.String("filter", string(vtubers.AliasFilterOriginal), "false positive filter: original, english or none")
+	v, err := parseVTuberFlags(ctx, store, fs, args, true)
+	if err != nil {
+		return err
+	}
+
+	aliasFilter := vtubers.AliasFilter(*filter)
+	switch aliasFilter {
+	case vtubers.AliasFilterOriginal, vtubers.AliasFilterEnglish, vtubers.AliasFilterNone:
+	default:
+		return fmt.Errorf("unknown filter %q", *filter)
+	}
+
+	for _, alias := range fs.Args() {
+		err := store.AddAlias(ctx, vtubers.Alias{
+			VTuberID: v.ID,
+			Alias:    alias,
+			Source:   vtubers.AliasSourceAdmin,
+			Filter:   aliasFilter,
+		})
+		if err != nil {
+			return fmt.Errorf("add alias %q: %w", alias, err)
+		}
+		log.Printf("Added alias %q for %s (%d)", alias, v.EnglishName, v.ID)
+	}
+
+	return logUpdate(ctx, store, v.ID)
+}
+
+func removeAliases(ctx context.Context, store *vtubers.Store, args []string) error {
+	fs := flag.NewFlagSet("alias remove", flag.ExitOnError)
+	v, err := parseVTuberFlags(ctx, store, fs, args, true)
+	if err != nil {
+		return err
+	}
+
+	anyRemoved := false
+	for _, alias := range fs.Args() {
+		removed, err := store.RemoveAlias(ctx, v.ID, alias)
+		if err != nil {
+			return fmt.Errorf("remove alias %q: %w", alias, err)
+		}
+		if removed {
+			log.Printf("Removed alias %q for %s (%d)", alias, v.EnglishName, v.ID)
+		} else {
+			log.Printf("No alias %q for %s (%d)", alias, v.EnglishName, v.ID)
+		}
+		anyRemoved = anyRemoved || removed
+	}
+
+	// Nothing to reindex when the dictionary is unchanged.
+	if !anyRemoved {
+		return nil
+	}
+	return logUpdate(ctx, store, v.ID)
+}
+
+// Logs an update of the vtuber so that the indexer applies the dictionary
+// changes to existing videos, and rebuilds the stored dictionary.
+func logUpdate(ctx context.Context, store *vtubers.Store, vtuberID int) error {
+	if err := store.LogUpdate(ctx, []int{vtuberID}); err != nil {
+		return err
+	}
+	return store.RebuildDictionary(ctx)
+}
+
+func listAliases(ctx context.Context, store *vtubers.Store, args []string) error {
+	fs := flag.NewFlagSet("alias list", flag.ExitOnError)
+	v, err := parseVTuberFlags(ctx, store, fs, args, false)
+	if err != nil {
+		return err
+	}
+
+	var aliases []vtubers.Alias
+	if v.ID != 0 {
+		aliases, err = store.GetAliasesForVTuber(ctx, v.ID)
+	} else {
+		aliases, err = store.GetAliases(ctx)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, a := range aliases {
+		fmt.Printf("%d\t%s\t%s\t%s\n", a.VTuberID, a.Alias, a.Source, a.Filter)
+	}
+	return nil
+}
+
+func addRules(ctx context.Context, store *vtubers.Store, args []string) error {
+	fs := flag.NewFlagSet("rule add", flag.ExitOnError)
+	kind := fs.String("kind", string(vtubers.RuleExclude), "whether the pattern must be absent or present: exclude or require")
+	ruleContext := fs.String("context", string(vtubers.RuleContextTitle), "text the pattern is matched against: title, before or after the name")
+	name := fs.String("name", "", "name or alias the rule applies to, all names if empty")
+	v, err := parseVTuberFlags(ctx, store, fs, args, true)
+	if err != nil {
+		return err
+	}
+
+	for _, pattern := range fs.Args() {
+		id, err := store.AddRule(ctx, vtubers.Rule{
+			VTuberID: v.ID,
+			Name:     *name,
+			Kind:     vtubers.RuleKind(*kind),
+			Context:  vtubers.RuleContext(*ruleContext),
+			Pattern:  pattern,
+		})
+		if err != nil {
+			return fmt.Errorf("add rule %q: %w", pattern, err)
+		}
+		log.Printf("Added rule %d for %s (%d)", id, v.EnglishName, v.ID)
+	}
+
+	return logUpdate(ctx, store, v.ID)
+}
+
+func removeRules(ctx context.Context, store *vtubers.Store, args []string) error {
+	fs := flag.NewFlagSet("rule remove", flag.ExitOnError)
+	v, err := parseVTuberFlags(ctx, store, fs, args, true)
+	if err != nil {
+		return err
+	}
+
+	anyRemoved := false
+	for _, arg := range fs.Args() {
+		id, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			return fmt.Errorf("rule id %q: %w", arg, err)
+		}
+		removed, err := store.RemoveRule(ctx, v.ID, id)
+		if err != nil {
+			return fmt.Errorf("remove rule %d: %w", id, err)
+		}
+		if removed {
+			log.Printf("Removed rule %d for %s (%d)", id, v.EnglishName, v.ID)
+		} else {
+			log.Printf("No rule %d for %s (%d)", id, v.EnglishName, v.ID)
+		}
+		anyRemoved = anyRemoved || removed
+	}
+
+	if !anyRemoved {
+		return nil
+	}
+	return logUpdate(ctx, store, v.ID)
+}
+
+func listRules(ctx context.Context, store *vtubers.Store, args []string) error {
+	fs := flag.NewFlagSet("rule list", flag.ExitOnError)
+	v, err := parseVTuberFlags(ctx, store, fs, args, false)
+	if err != nil {
+		return err
+	}
+
+	var rules []vtubers.Rule
+	if v.ID != 0 {
+		rules, err = store.GetRulesForVTuber(ctx, v.ID)
+	} else {
+		rules, err = store.GetRules(ctx)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, r := range rules {
+		fmt.Printf("%d\t%d\t%s\t%s\t%s\t%s", r.ID, r.VTuberID, r.Kind, r.Context, r.Name, r.Pattern)
+		// Detectors can't be loaded until these are removed.
+		if err := r.Validate(); err != nil {
+			fmt.Printf("\tinvalid: %s", err)
+		}
+		fmt.Println()
+	}
+	return nil
+}
+
+// Adds, replaces or deletes an override and applies it to the video.
+func setOverride(
+	ctx context.Context,
+	store *vtubers.Store,
+	indexRepo *index.IndexedVideoRepository,
+	subcommand string,
+	args []string,
+) error {
+	fs := flag.NewFlagSet("override "+subcommand, flag.ExitOnError)
+	videoID := fs.String("video", "", "id of the video")
+	userID := fs.String("user", "", "id of the user the override applies to, all users if empty")
+	v, err := parseVTuberFlags(ctx, store, fs, args, false)
+	if err != nil {
+		return err
+	}
+	if *videoID == "" || v.ID == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if subcommand == "delete" {
+		deleted, err := indexRepo.DeleteOverride(ctx, *videoID, *userID, v.ID)
+		if err != nil {
+			return fmt.Errorf("delete override: %w", err)
+		}
+		if !deleted {
+			log.Printf("No override for %s (%d) in %s", v.EnglishName, v.ID, *videoID)
+			return nil
+		}
+		log.Printf("Deleted override for %s (%d) in %s", v.EnglishName, v.ID, *videoID)
+	} else {
+		err := indexRepo.SetOverride(ctx, index.AttributionOverride{
+			VideoID:  *videoID,
+			UserID:   *userID,
+			VTuberID: v.ID,
+			Action:   index.OverrideAction(subcommand),
+		})
+		if err != nil {
+			return fmt.Errorf("set override: %w", err)
+		}
+		log.Printf("Set override to %s %s (%d) in %s", subcommand, v.EnglishName, v.ID, *videoID)
+	}
+
+	detector, err := vtubers.CreateDetector(ctx, store)
+	if err != nil {
+		return err
+	}
+	err = indexRepo.ReindexVideo(ctx, detector, *videoID)
+	if errors.Is(err, sql.ErrNoRows) {
+		log.Printf("Video %s is not indexed yet, the override is applied once it is", *videoID)
+		return nil
+	}
+	return err
+}
+
+func listOverrides(ctx context.Context, indexRepo *index.IndexedVideoRepository, args []string) error {
+	fs := flag.NewFlagSet("override list", flag.ExitOnError)
+	videoID := fs.String("video", "", "id of the video")
+	fs.Parse(args)
+
+	overrides, err := indexRepo.GetOverrides(ctx, *videoID)
+	if err != nil {
+		return err
+	}
+
+	for _, o := range overrides {
+		fmt.Printf("%s\t%s\t%d\t%s\n", o.VideoID, o.UserID, o.VTuberID, o.Action)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/xoltia/botsu-oshi-stats/vtubers"
+)
+
+func main() {
+	log.SetFlags(0)
+
+	var (
+		rosterPath   string
+		minPrecision float64
+		minRecall    float64
+	)
+	flag.StringVar(&rosterPath, "roster", "", "roster file to detect with instead of the stored dictionary")
+	flag.Float64Var(&minPrecision, "min-precision", 0, "exit with an error when precision is lower")
+	flag.Float64Var(&minRecall, "min-recall", 0, "exit with an error when recall is lower")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: evaluate [-roster <file>] [-min-precision <p>] [-min-recall <r>] <labeled videos file>...")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var videos []vtubers.LabeledVideo
+	for _, path := range flag.Args() {
+		var labeled []vtubers.LabeledVideo
+		if err := readJSON(path, &labeled); err != nil {
+			log.Fatalln(err)
+		}
+		videos = append(videos, labeled...)
+	}
+
+	var detector *vtubers.Detector
+	if rosterPath != "" {
+		var roster vtubers.Roster
+		if err := readJSON(rosterPath, &roster); err != nil {
+			log.Fatalln(err)
+		}
+		for _, r := range roster.Rules {
+			if err := r.Validate(); err != nil {
+				log.Fatalf("%s: rule %d of vtuber %d: %s", rosterPath, r.ID, r.VTuberID, err)
+			}
+		}
+		detector = vtubers.NewDetector(roster.VTubers, roster.Aliases, roster.Rules)
+	} else {
+		detector = createDetector()
+	}
+
+	evaluation := vtubers.Evaluate(detector, videos)
+	if err := evaluation.WriteText(os.Stdout); err != nil {
+		log.Panicln(err)
+	}
+
+	if evaluation.Precision() < minPrecision {
+		log.Fatalf("Precision %.3f is below %.3f", evaluation.Precision(), minPrecision)
+	}
+	if evaluation.Recall() < minRecall {
+		log.Fatalf("Recall %.3f is below %.3f", evaluation.Recall(), minRecall)
+	}
+}
+
+// Creates a detector from the stored dictionary, as the indexer would.
+func createDetector() *vtubers.Detector {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	db, err := sqlx.Open("sqlite3", "oshistats.db?_journal_mode=WAL")
+	if err != nil {
+		log.Panicln(err)
+	}
+	defer db.Close()
+
+	store, err := vtubers.CreateStore(ctx, db)
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	detector, err := vtubers.CreateDetector(ctx, store)
+	if err != nil {
+		log.Panicln(err)
+	}
+	return detector
+}
+
+func readJSON(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
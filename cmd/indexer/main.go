@@ -6,6 +6,7 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"time"
 
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/jmoiron/sqlx"
@@ -16,8 +17,21 @@ import (
 )
 
 func main() {
-	var dbURL string
+	var (
+		dbURL          string
+		full           bool
+		watch          bool
+		installTrigger bool
+		pollInterval   time.Duration
+		options        index.IndexOptions
+	)
 	flag.StringVar(&dbURL, "db-url", "", "url to connect to postgres db")
+	flag.BoolVar(&full, "full", false, "clear the index and rebuild it from all logs")
+	flag.BoolVar(&watch, "watch", false, "keep running and index changes as they are notified, which requires the notification trigger")
+	flag.BoolVar(&installTrigger, "install-trigger", false, "create the activities notification trigger used by -watch, which also records changes for reconciling")
+	flag.DurationVar(&pollInterval, "poll-interval", 5*time.Minute, "interval for a full index run in watch mode")
+	flag.IntVar(&options.BatchSize, "batch-size", 1000, "logs written per transaction, or all in one if zero")
+	flag.IntVar(&options.Workers, "workers", 0, "number of detection workers, or the number of cpus if zero")
 	flag.Parse()
 
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
@@ -42,20 +56,79 @@ func main() {
 
 	logRepository := logs.NewRepository(pgDB)
 
-	ls, err := logRepository.GetAll(ctx)
-	if err != nil {
-		log.Panicln(err)
+	if installTrigger {
+		if err := logRepository.InstallNotifyTrigger(ctx); err != nil {
+			log.Panicln(err)
+		}
+	}
+
+	// Without the trigger, nothing is notified and every poll
+	// compares all logs to find what changed.
+	if watch {
+		hasChangeLog, err := logRepository.HasChangeLog(ctx)
+		if err != nil {
+			log.Panicln(err)
+		}
+		if !hasChangeLog {
+			log.Fatalln("The notification trigger used by -watch isn't installed, run with -install-trigger")
+		}
 	}
-	defer ls.Close()
 
 	repo, err := index.CreateIndexedVideoRepository(ctx, db)
 	if err != nil {
 		log.Panicln(err)
 	}
 
-	indexer := index.NewIndexer(store, logRepository, repo)
-	err = indexer.Index(ctx)
+	indexer := index.NewIndexer(store, logRepository, repo, options)
+	if full {
+		err = indexer.Rebuild(ctx)
+	} else {
+		err = indexer.Index(ctx)
+	}
 	if err != nil {
 		log.Panicln(err)
 	}
+
+	if watch {
+		changes := logs.Listen(ctx, dbURL, 10*time.Second, func(err error) {
+			log.Printf("Listener error: %s", err)
+		})
+		watchChanges(ctx, indexer, changes, pollInterval)
+	}
+}
+
+// Indexes new logs as soon as they are inserted and runs a full index
+// on other changes or when no notification was received for pollInterval.
+// Errors are logged and retried on the next change or poll.
+func watchChanges(
+	ctx context.Context,
+	indexer *index.Indexer,
+	changes <-chan logs.Change,
+	pollInterval time.Duration,
+) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		var err error
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err = indexer.Index(ctx)
+		case change, ok := <-changes:
+			if !ok {
+				return
+			}
+			if change == logs.ChangeInsert {
+				err = indexer.IndexNew(ctx)
+			} else {
+				err = indexer.Index(ctx)
+				ticker.Reset(pollInterval)
+			}
+		}
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Index error: %s", err)
+		}
+	}
 }
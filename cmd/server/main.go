@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/jmoiron/sqlx"
@@ -27,6 +28,7 @@ func main() {
 		imgproxyConfig      server.ImgproxyConfig
 		imgproxySigningKey  string
 		imgproxySigningSalt string
+		debugUsers          string
 	)
 	flag.StringVar(&dbURL, "db-url", "postgresql:///botsu", "url to connect to postgres db")
 	flag.StringVar(&addr, "addr", ":8080", "address to listen on")
@@ -36,6 +38,7 @@ func main() {
 	flag.StringVar(&imgproxyConfig.Host, "imgproxy-host", "", "imgproxy host")
 	flag.StringVar(&imgproxySigningKey, "imgproxy-key", "", "imgproxy signing key")
 	flag.StringVar(&imgproxySigningSalt, "imgproxy-salt", "", "imgproxy signing salt")
+	flag.StringVar(&debugUsers, "debug-users", "", "comma separated discord ids of users allowed to use the debug endpoints")
 	flag.Parse()
 
 	var err error
@@ -53,6 +56,11 @@ func main() {
 		oauthConfig.ClientSecret = os.Getenv("BOTSU_WEB_OAUTH_CLIENT_SECRET")
 	}
 
+	var debugConfig server.DebugConfig
+	if debugUsers != "" {
+		debugConfig.UserIDs = strings.Split(debugUsers, ",")
+	}
+
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer cancel()
 
@@ -98,6 +106,7 @@ func main() {
 		sessionStore,
 		oauthConfig,
 		imgproxyConfig,
+		debugConfig,
 	)
 	err = http.ListenAndServe(addr, s)
 	if err != nil {
@@ -16,9 +16,19 @@ import (
 )
 
 func main() {
-	options := vtubers.UpdateOptions{}
+	var (
+		options      vtubers.UpdateOptions
+		rosters      []vtubers.TalentSource
+		rostersFirst bool
+	)
 	flag.StringVar(&options.GoogleAPIKey, "google-api-key", "", "google api key for youtube data api")
 	flag.BoolVar(&options.ChannelsOnly, "channels-only", false, "only update channel data")
+	flag.Func("roster", "csv or json file of vtubers to import, may be repeated in order of precedence", func(s string) error {
+		rosters = append(rosters, &vtubers.FileSource{Path: s})
+		return nil
+	})
+	flag.BoolVar(&options.AllowRemovals, "allow-removals", false, "remove vtubers when a source has far fewer records than before or is no longer given")
+	flag.BoolVar(&rostersFirst, "roster-precedence", false, "prefer roster files over hololist when both have a field")
 	flag.Parse()
 
 	if options.GoogleAPIKey == "" {
@@ -36,14 +46,22 @@ func main() {
 
 	client := &http.Client{}
 	limiter := rate.NewLimiter(rate.Limit(time.Second), 2)
-	scraper := vtubers.NewHololistScraper(client, limiter)
 	vtuberStore, err := vtubers.CreateStore(ctx, db)
 	if err != nil {
 		log.Panicln(err)
 	}
 
+	hololist := &vtubers.HololistSource{
+		Scraper: vtubers.NewHololistScraper(client, limiter),
+		Store:   vtuberStore,
+	}
+	sources := append([]vtubers.TalentSource{hololist}, rosters...)
+	if rostersFirst {
+		sources = append(rosters, hololist)
+	}
+
 	updater := vtubers.Updater{
-		Scraper: scraper,
+		Sources: sources,
 		Store:   vtuberStore,
 		Options: options,
 	}
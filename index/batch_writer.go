@@ -0,0 +1,267 @@
+package index
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/xoltia/botsu-oshi-stats/logs"
+	"github.com/xoltia/botsu-oshi-stats/vtubers"
+)
+
+// BatchWriter performs all writes to the index within transactions using
+// prepared statements. Writes for a batch of logs are committed together
+// with the index cursor, so an interrupted run leaves the index consistent
+// with the cursor and may be resumed. A transaction is started on the first
+// write and must be ended with Commit or Rollback. Not safe for concurrent use.
+type BatchWriter struct {
+	db        *sqlx.DB
+	batchSize int
+	pending   int
+
+	tx                  *sqlx.Tx
+	insertVideoVTuber   *sqlx.Stmt
+	insertVideoHistory  *sqlx.Stmt
+	upsertVideo         *sqlx.Stmt
+	setCursor           *sqlx.Stmt
+	replaceVideoVTubers *sqlx.Stmt
+}
+
+// NewBatchWriter creates a writer that commits after every batchSize logs
+// passed to Checkpoint. A batch size of zero or less writes everything in
+// a single transaction.
+func (r *IndexedVideoRepository) NewBatchWriter(batchSize int) *BatchWriter {
+	return &BatchWriter{db: r.db, batchSize: batchSize}
+}
+
+func (w *BatchWriter) begin(ctx context.Context) error {
+	if w.tx != nil {
+		return nil
+	}
+
+	tx, err := w.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin: %w", err)
+	}
+
+	prepare := func(query string) *sqlx.Stmt {
+		if err != nil {
+			return nil
+		}
+		var stmt *sqlx.Stmt
+		stmt, err = tx.PreparexContext(ctx, query)
+		return stmt
+	}
+
+	w.insertVideoVTuber = prepare(`
+		INSERT INTO video_vtubers (user_id, video_id, vtuber_id, source, confidence)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT DO NOTHING
+	`)
+	w.insertVideoHistory = prepare(`
+		INSERT INTO video_history (user_id, video_id, log_id, date, date_local, duration)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT DO NOTHING
+	`)
+	w.upsertVideo = prepare(`
+		INSERT INTO videos (id, meta)
+		VALUES (?, ?)
+		ON CONFLICT (id) DO UPDATE
+		SET meta = excluded.meta
+	`)
+	w.setCursor = prepare(`
+		INSERT INTO index_cursor (id, log_id, log_date)
+		VALUES (0, ?, ?)
+		ON CONFLICT (id) DO UPDATE
+		SET log_id = excluded.log_id,
+			log_date = excluded.log_date
+	`)
+	w.replaceVideoVTubers = prepare(`
+		INSERT INTO video_vtubers (user_id, video_id, vtuber_id, source, confidence)
+		SELECT DISTINCT user_id, video_id, ?, ?, ?
+		FROM video_history
+		WHERE video_id = ?
+		ON CONFLICT DO NOTHING
+	`)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("prepare: %w", err)
+	}
+
+	w.tx = tx
+	return nil
+}
+
+func (w *BatchWriter) exec(ctx context.Context, query string, args ...any) error {
+	if err := w.begin(ctx); err != nil {
+		return err
+	}
+	_, err := w.tx.ExecContext(ctx, query, args...)
+	return err
+}
+
+// Commit commits all writes since the last commit.
+func (w *BatchWriter) Commit() error {
+	if w.tx == nil {
+		return nil
+	}
+	err := w.tx.Commit()
+	w.tx = nil
+	w.pending = 0
+	return err
+}
+
+// Rollback discards all writes since the last commit. Safe to call after Commit.
+func (w *BatchWriter) Rollback() error {
+	if w.tx == nil {
+		return nil
+	}
+	err := w.tx.Rollback()
+	w.tx = nil
+	w.pending = 0
+	return err
+}
+
+// Checkpoint records the cursor of the last fully written log,
+// committing if the batch is full.
+func (w *BatchWriter) Checkpoint(ctx context.Context, c IndexCursor) error {
+	if err := w.begin(ctx); err != nil {
+		return err
+	}
+	if _, err := w.setCursor.ExecContext(ctx, c.LogID, c.LogDate.UTC()); err != nil {
+		return err
+	}
+	w.pending++
+	if w.batchSize > 0 && w.pending >= w.batchSize {
+		return w.Commit()
+	}
+	return nil
+}
+
+func (w *BatchWriter) SetAppliedUpdate(ctx context.Context, updateID int64) error {
+	return w.exec(ctx, `
+		INSERT INTO index_applied_update (id, update_id)
+		VALUES (0, ?)
+		ON CONFLICT (id) DO UPDATE
+		SET update_id = excluded.update_id
+	`, updateID)
+}
+
+func (w *BatchWriter) SetReconciledAt(ctx context.Context, t time.Time) error {
+	return w.exec(ctx, `
+		INSERT INTO index_reconciled (id, reconciled_at)
+		VALUES (0, ?)
+		ON CONFLICT (id) DO UPDATE
+		SET reconciled_at = excluded.reconciled_at
+	`, t.UTC())
+}
+
+// ClearReconciledAt records that the index was never reconciled while the change log existed.
+func (w *BatchWriter) ClearReconciledAt(ctx context.Context) error {
+	return w.exec(ctx, "DELETE FROM index_reconciled")
+}
+
+// Clear removes all indexed data including the cursor.
+func (w *BatchWriter) Clear(ctx context.Context) error {
+	return w.exec(ctx, clearIndexQuery)
+}
+
+func (w *BatchWriter) InsertVideoVTuber(
+	ctx context.Context,
+	userID string,
+	videoID string,
+	vtuberID int,
+	source vtubers.Source,
+) error {
+	if err := w.begin(ctx); err != nil {
+		return err
+	}
+	_, err := w.insertVideoVTuber.ExecContext(
+		ctx,
+		userID,
+		videoID,
+		vtuberID,
+		source.String(),
+		source.Confidence())
+	return err
+}
+
+func (w *BatchWriter) InsertVideoHistory(
+	ctx context.Context,
+	userID string,
+	videoID string,
+	logID int,
+	date time.Time,
+	duration time.Duration,
+) error {
+	if err := w.begin(ctx); err != nil {
+		return err
+	}
+	_, err := w.insertVideoHistory.ExecContext(ctx, userID, videoID, logID, date.UTC(), date, duration)
+	return err
+}
+
+// UpsertVideo stores the video information used for detection so that
+// attributions can later be recomputed without the log repository.
+func (w *BatchWriter) UpsertVideo(ctx context.Context, video logs.VideoInfo) error {
+	if err := w.begin(ctx); err != nil {
+		return err
+	}
+	meta, err := json.Marshal(video)
+	if err != nil {
+		return fmt.Errorf("marshal: %w", err)
+	}
+	_, err = w.upsertVideo.ExecContext(ctx, video.ID, meta)
+	return err
+}
+
+// ReplaceVideoVTubers sets the vtubers attributed to a video for every user who watched it.
+func (w *BatchWriter) ReplaceVideoVTubers(ctx context.Context, videoID string, detections []vtubers.Detection) error {
+	if err := w.exec(ctx, "DELETE FROM video_vtubers WHERE video_id = ?", videoID); err != nil {
+		return err
+	}
+	for _, d := range detections {
+		_, err := w.replaceVideoVTubers.ExecContext(
+			ctx,
+			d.VTuber.ID,
+			d.Source.String(),
+			d.Source.Confidence(),
+			videoID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *BatchWriter) UpdateVideoHistoryDuration(
+	ctx context.Context,
+	logID int,
+	duration time.Duration,
+) error {
+	return w.exec(ctx, "UPDATE video_history SET duration = ? WHERE log_id = ?", duration, logID)
+}
+
+func (w *BatchWriter) DeleteVideoHistory(ctx context.Context, logID int) error {
+	return w.exec(ctx, "DELETE FROM video_history WHERE log_id = ?", logID)
+}
+
+// DeleteOrphans removes attributions and video information that are no
+// longer referenced by any video history.
+func (w *BatchWriter) DeleteOrphans(ctx context.Context) error {
+	return w.exec(ctx, `
+		DELETE FROM video_vtubers
+		WHERE NOT EXISTS (
+			SELECT 1 FROM video_history vh
+			WHERE vh.video_id = video_vtubers.video_id AND vh.user_id = video_vtubers.user_id
+		);
+
+		DELETE FROM videos
+		WHERE NOT EXISTS (
+			SELECT 1 FROM video_history vh
+			WHERE vh.video_id = videos.id
+		);
+	`)
+}
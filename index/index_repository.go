@@ -2,10 +2,15 @@ package index
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/xoltia/botsu-oshi-stats/internal/migrate"
+	"github.com/xoltia/botsu-oshi-stats/logs"
 	"github.com/xoltia/botsu-oshi-stats/vtubers"
 )
 
@@ -18,12 +23,41 @@ type IndexedVideoHistory struct {
 }
 
 type IndexedVideoVTuber struct {
-	LogID    int    `db:"log_id"`
-	VideoID  string `db:"video_id"`
-	UserID   string `db:"user_id"`
-	VTuberID int    `db:"vtuber_id"`
+	LogID      int     `db:"log_id"`
+	VideoID    string  `db:"video_id"`
+	UserID     string  `db:"user_id"`
+	VTuberID   int     `db:"vtuber_id"`
+	Source     string  `db:"source"`
+	Confidence float64 `db:"confidence"`
 }
 
+// IndexCursor marks the last log processed by the indexer.
+type IndexCursor struct {
+	LogID   int       `db:"log_id"`
+	LogDate time.Time `db:"log_date"`
+}
+
+func (c IndexCursor) IsZero() bool {
+	return c.LogID == 0
+}
+
+// Incremented when changes to how detections are attributed invalidate
+// existing indexes, which are cleared to be rebuilt by the next run.
+//
+// Version 1 attributes vtubers of linked channels found by name in the
+// title as found by name, where they were previously left out.
+const indexVersion = 1
+
+// Removes all indexed data including the cursor, leaving overrides.
+const clearIndexQuery = `
+	DELETE FROM video_vtubers;
+	DELETE FROM video_history;
+	DELETE FROM videos;
+	DELETE FROM index_cursor;
+	DELETE FROM index_applied_update;
+	DELETE FROM index_reconciled;
+`
+
 type IndexedVideoRepository struct {
 	db *sqlx.DB
 }
@@ -34,6 +68,8 @@ func CreateIndexedVideoRepository(ctx context.Context, db *sqlx.DB) (*IndexedVid
 			video_id TEXT NOT NULL,
 			user_id TEXT NOT NULL,
 			vtuber_id TEXT NOT NULL,
+			source TEXT NOT NULL DEFAULT '',
+			confidence REAL NOT NULL DEFAULT 0,
 
 			FOREIGN KEY (vtuber_id) REFERENCES vtubers(id),
 			PRIMARY KEY (video_id, user_id, vtuber_id)	
@@ -47,14 +83,164 @@ func CreateIndexedVideoRepository(ctx context.Context, db *sqlx.DB) (*IndexedVid
 			date_local TEXT NOT NULL,
 			duration INTEGER NOT NULL
 		);
+
+		CREATE TABLE IF NOT EXISTS index_cursor (
+			id INTEGER NOT NULL PRIMARY KEY CHECK (id = 0),
+			log_id INTEGER NOT NULL,
+			log_date TIMESTAMP NOT NULL
+		);
+
+		CREATE TABLE IF NOT EXISTS index_applied_update (
+			id INTEGER NOT NULL PRIMARY KEY CHECK (id = 0),
+			update_id INTEGER NOT NULL
+		);
+
+		CREATE TABLE IF NOT EXISTS index_reconciled (
+			id INTEGER NOT NULL PRIMARY KEY CHECK (id = 0),
+			reconciled_at TIMESTAMP NOT NULL
+		);
+
+		CREATE TABLE IF NOT EXISTS index_version (
+			id INTEGER NOT NULL PRIMARY KEY CHECK (id = 0),
+			version INTEGER NOT NULL
+		);
+
+		CREATE TABLE IF NOT EXISTS videos (
+			id TEXT NOT NULL PRIMARY KEY,
+			meta BLOB NOT NULL
+		);
+
+		CREATE TABLE IF NOT EXISTS attribution_overrides (
+			video_id TEXT NOT NULL,
+			user_id TEXT NOT NULL,
+			vtuber_id INTEGER NOT NULL,
+			action TEXT NOT NULL,
+
+			PRIMARY KEY (video_id, user_id, vtuber_id)
+		);
 	`)
 	if err != nil {
 		return nil, err
 	}
 
+	// Attributions indexed before these columns existed have no source and
+	// would be left out of stats, so the index is cleared to be rebuilt by
+	// the next run. Overrides are kept and applied again.
+	sourceAdded, err := migrate.AddColumn(ctx, db, "video_vtubers", "source", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return nil, err
+	}
+	_, err = migrate.AddColumn(ctx, db, "video_vtubers", "confidence", "REAL NOT NULL DEFAULT 0")
+	if err != nil {
+		return nil, err
+	}
+	if sourceAdded {
+		if _, err := db.ExecContext(ctx, clearIndexQuery); err != nil {
+			return nil, fmt.Errorf("clear index: %w", err)
+		}
+	}
+
+	var version int
+	err = db.GetContext(ctx, &version, "SELECT version FROM index_version WHERE id = 0")
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("get version: %w", err)
+	}
+	if version != indexVersion {
+		// Clearing an index that has yet to be built does nothing, so
+		// there's no need to tell it apart from one without a version.
+		_, err := db.ExecContext(ctx, clearIndexQuery+`
+			INSERT INTO index_version (id, version) VALUES (0, ?)
+			ON CONFLICT DO UPDATE SET version = excluded.version;
+		`, indexVersion)
+		if err != nil {
+			return nil, fmt.Errorf("clear outdated index: %w", err)
+		}
+	}
+
 	return &IndexedVideoRepository{db}, nil
 }
 
+// GetCursor returns the current index cursor, or the zero cursor if
+// nothing has been indexed yet.
+func (r *IndexedVideoRepository) GetCursor(ctx context.Context) (IndexCursor, error) {
+	var c IndexCursor
+	err := r.db.GetContext(ctx, &c, "SELECT log_id, log_date FROM index_cursor WHERE id = 0")
+	if errors.Is(err, sql.ErrNoRows) {
+		return IndexCursor{}, nil
+	}
+	return c, err
+}
+
+// GetAppliedUpdate returns the ID of the last vtuber store update
+// reflected in the index, or zero if none has been applied.
+func (r *IndexedVideoRepository) GetAppliedUpdate(ctx context.Context) (int64, error) {
+	var updateID int64
+	err := r.db.GetContext(ctx, &updateID, "SELECT update_id FROM index_applied_update WHERE id = 0")
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return updateID, err
+}
+
+// GetReconciledAt returns the log repository time when the index was last
+// reconciled while the change log existed, or the zero time if it never was.
+// Changes since then are all in the change log.
+func (r *IndexedVideoRepository) GetReconciledAt(ctx context.Context) (time.Time, error) {
+	var t time.Time
+	err := r.db.GetContext(ctx, &t, "SELECT reconciled_at FROM index_reconciled WHERE id = 0")
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
+	return t, err
+}
+
+func (r *IndexedVideoRepository) GetVideos(ctx context.Context) ([]logs.VideoInfo, error) {
+	rows, err := r.db.QueryxContext(ctx, "SELECT meta FROM videos")
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	defer rows.Close()
+
+	result := make([]logs.VideoInfo, 0)
+	for rows.Next() {
+		var video logs.VideoInfo
+		if err := rows.Scan(&video); err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+		result = append(result, video)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("next: %w", err)
+	}
+
+	return result, nil
+}
+
+// GetVideo returns the metadata of an indexed video, or sql.ErrNoRows if it isn't indexed.
+func (r *IndexedVideoRepository) GetVideo(ctx context.Context, id string) (video logs.VideoInfo, err error) {
+	err = r.db.QueryRowxContext(ctx, "SELECT meta FROM videos WHERE id = $1", id).Scan(&video)
+	return
+}
+
+// GetVideoIDsForVTubers returns the IDs of all videos attributed to any of the given vtubers.
+func (r *IndexedVideoRepository) GetVideoIDsForVTubers(ctx context.Context, vtuberIDs []int) ([]string, error) {
+	if len(vtuberIDs) == 0 {
+		return nil, nil
+	}
+	query, args, err := sqlx.In(`
+		SELECT DISTINCT video_id
+		FROM video_vtubers
+		WHERE vtuber_id IN (?)
+	`, vtuberIDs)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	err = r.db.SelectContext(ctx, &ids, query, args...)
+	return ids, err
+}
+
 func (r *IndexedVideoRepository) GetVTubersForVideo(
 	ctx context.Context,
 	userID string,
@@ -88,34 +274,51 @@ func (r *IndexedVideoRepository) GetVTubersForVideo(
 	return result, nil
 }
 
-func (r *IndexedVideoRepository) InsertVideoVTuber(
-	ctx context.Context,
-	userID string,
-	videoID string,
-	vtuberID int,
-) error {
-	_, err := r.db.ExecContext(ctx, `
-		INSERT INTO video_vtubers (user_id, video_id, vtuber_id)
-		VALUES (?, ?, ?)
-		ON CONFLICT DO NOTHING
-	`, userID, videoID, vtuberID)
-	return err
+// GetLogDurations returns the duration of every indexed log keyed by log ID.
+func (r *IndexedVideoRepository) GetLogDurations(ctx context.Context) (map[int]time.Duration, error) {
+	rows, err := r.db.QueryxContext(ctx, "SELECT log_id, duration FROM video_history")
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	defer rows.Close()
+
+	result := make(map[int]time.Duration)
+	for rows.Next() {
+		var (
+			logID    int
+			duration time.Duration
+		)
+		if err := rows.Scan(&logID, &duration); err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+		result[logID] = duration
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("next: %w", err)
+	}
+
+	return result, nil
 }
 
-func (r *IndexedVideoRepository) InsertVideoHistory(
-	ctx context.Context,
-	userID string,
-	videoID string,
-	logID int,
-	date time.Time,
-	duration time.Duration,
-) error {
-	_, err := r.db.ExecContext(ctx, `
-		INSERT INTO video_history (user_id, video_id, log_id, date, date_local, duration)
-		VALUES (?, ?, ?, ?, ?, ?)
-		ON CONFLICT DO NOTHING
-	`, userID, videoID, logID, date.UTC(), date, duration)
-	return err
+// GetIndexedLogIDs returns which of the given logs are indexed.
+func (r *IndexedVideoRepository) GetIndexedLogIDs(ctx context.Context, logIDs []int) (map[int]bool, error) {
+	result := make(map[int]bool)
+	if len(logIDs) == 0 {
+		return result, nil
+	}
+	query, args, err := sqlx.In("SELECT log_id FROM video_history WHERE log_id IN (?)", logIDs)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int
+	if err := r.db.SelectContext(ctx, &ids, query, args...); err != nil {
+		return nil, fmt.Errorf("select: %w", err)
+	}
+	for _, id := range ids {
+		result[id] = true
+	}
+	return result, nil
 }
 
 type VTuberWithApperances struct {
@@ -128,12 +331,60 @@ type VTuberWithDuration struct {
 	Duration time.Duration `db:"duration"`
 }
 
+type GetTopVTubersParams struct {
+	UserID     string
+	Start, End time.Time
+	Limit      int
+	// Only count attributions with at least this confidence.
+	MinConfidence float64
+	// Used when ranking by duration. Defaults to DurationPolicyOwnerWeighted.
+	Policy DurationPolicy
+	// Only count attributions detected by one of these sources, or any if empty.
+	Sources []vtubers.Source
+}
+
+// DurationPolicy decides how the duration of a video is credited to the
+// guests attributed to it, meaning the vtubers who don't own its channel.
+// Owners are always credited the full duration of videos on their channel.
+type DurationPolicy string
+
+const (
+	// Guests on a vtuber's own channel split half of the duration, as the
+	// video is mostly the owner's. Elsewhere the duration is split evenly.
+	DurationPolicyOwnerWeighted DurationPolicy = "owner-weighted"
+	// Every guest is credited the full duration.
+	DurationPolicyFull DurationPolicy = "full"
+	// The duration is split evenly between the guests.
+	DurationPolicySplit DurationPolicy = "split"
+)
+
+// Returns conditions on video_vtubers aliased as vv to be appended to
+// a WHERE clause, and their arguments.
+func (p GetTopVTubersParams) attributionFilter() (string, []any) {
+	var (
+		conditions strings.Builder
+		args       []any
+	)
+	if p.MinConfidence > 0 {
+		conditions.WriteString(" AND vv.confidence >= ?")
+		args = append(args, p.MinConfidence)
+	}
+	if len(p.Sources) > 0 {
+		conditions.WriteString(" AND vv.source IN (?" + strings.Repeat(", ?", len(p.Sources)-1) + ")")
+		for _, source := range p.Sources {
+			args = append(args, source.String())
+		}
+	}
+	return conditions.String(), args
+}
+
 func (r *IndexedVideoRepository) GetTopVTubersByAppearenceCount(
 	ctx context.Context,
-	userID string,
-	start, end time.Time,
-	limit int,
+	params GetTopVTubersParams,
 ) ([]VTuberWithApperances, error) {
+	filter, filterArgs := params.attributionFilter()
+	args := append([]any{params.UserID, params.Start.UTC(), params.End.UTC()}, filterArgs...)
+	args = append(args, params.Limit)
 	rows, err := r.db.QueryxContext(ctx, `
 		SELECT vtb.*, count(*) AS appearances
 		FROM video_history vh
@@ -142,11 +393,11 @@ func (r *IndexedVideoRepository) GetTopVTubersByAppearenceCount(
 		JOIN vtubers vtb
 		ON vv.vtuber_id = vtb.id
 		WHERE vh.user_id = ?
-		      AND date(vh.date) BETWEEN date(?) AND date(?)
+		      AND date(vh.date) BETWEEN date(?) AND date(?)`+filter+`
 		GROUP BY vtb.id
 		ORDER BY appearances DESC
 		LIMIT ?
-	`, userID, start.UTC(), end.UTC(), limit)
+	`, args...)
 
 	if err != nil {
 		return nil, fmt.Errorf("query: %w", err)
@@ -169,26 +420,67 @@ func (r *IndexedVideoRepository) GetTopVTubersByAppearenceCount(
 	return result, nil
 }
 
-// TODO: count main channel content only towards channel owner
+// GetTopVTubersByDuration ranks vtubers by total watch time, crediting the
+// duration of each log to its attributed vtubers according to params.Policy.
 func (r *IndexedVideoRepository) GetTopVTubersByDuration(
 	ctx context.Context,
-	userID string,
-	start, end time.Time,
-	limit int,
+	params GetTopVTubersParams,
 ) ([]VTuberWithDuration, error) {
+	var guestCredit string
+	switch params.Policy {
+	case DurationPolicyFull:
+		guestCredit = "a.duration"
+	case DurationPolicySplit:
+		guestCredit = "a.duration / a.guests"
+	case DurationPolicyOwnerWeighted, "":
+		guestCredit = `
+			CASE
+			WHEN a.owners = 0 THEN a.duration / a.guests
+			ELSE a.duration / (2 * a.guests)
+			END`
+	default:
+		return nil, fmt.Errorf("unknown duration policy %q", params.Policy)
+	}
+	credit := "CASE WHEN a.owner THEN a.duration ELSE " + guestCredit + " END"
+
+	filter, filterArgs := params.attributionFilter()
+	args := append([]any{params.UserID, params.Start.UTC(), params.End.UTC()}, filterArgs...)
+	args = append(args, params.Limit)
 	rows, err := r.db.QueryxContext(ctx, `
-		SELECT vtb.*, sum(vh.duration) AS duration
-		FROM video_history vh
-		JOIN video_vtubers vv
-		ON vh.video_id = vv.video_id AND vh.user_id = vv.user_id
+		WITH attributed AS (
+			SELECT
+				vv.vtuber_id,
+				vh.log_id,
+				vh.duration,
+				coalesce(json_extract(CAST(v.meta AS TEXT), '$.channel_id') = vtb.youtube_id, 0) AS owner
+			FROM video_history vh
+			JOIN video_vtubers vv
+			ON vh.video_id = vv.video_id AND vh.user_id = vv.user_id
+			JOIN vtubers vtb
+			ON vv.vtuber_id = vtb.id
+			LEFT JOIN videos v
+			ON v.id = vh.video_id
+			WHERE vh.user_id = ?
+			      AND date(vh.date) BETWEEN date(?) AND date(?)`+filter+`
+		),
+		attributions AS (
+			SELECT
+				vtuber_id,
+				duration,
+				owner,
+				sum(owner) OVER (PARTITION BY log_id) AS owners,
+				count(*) OVER (PARTITION BY log_id) - sum(owner) OVER (PARTITION BY log_id) AS guests
+			FROM attributed
+		)
+		SELECT vtb.*, sum(`+credit+`) AS duration
+		FROM attributions a
 		JOIN vtubers vtb
-		ON vv.vtuber_id = vtb.id
-		WHERE vh.user_id = ?
-		      AND date(vh.date) BETWEEN date(?) AND date(?)
+		ON a.vtuber_id = vtb.id
 		GROUP BY vtb.id
+		HAVING duration > 0
 		ORDER BY duration DESC
 		LIMIT ?
-	`, userID, start.UTC(), end.UTC(), limit)
+	`, args...)
 
 	if err != nil {
 		return nil, fmt.Errorf("query: %w", err)
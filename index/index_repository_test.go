@@ -0,0 +1,232 @@
+package index_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/xoltia/botsu-oshi-stats/index"
+	"github.com/xoltia/botsu-oshi-stats/logs"
+	"github.com/xoltia/botsu-oshi-stats/vtubers"
+)
+
+const testUser = "user"
+
+func openTestDB(t *testing.T) *sqlx.DB {
+	t.Helper()
+	// Shared between connections, as the indexer reads while writing.
+	// The database is gone once every connection is closed.
+	db, err := sqlx.Open("sqlite3", fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	db.SetConnMaxLifetime(0)
+	db.SetMaxIdleConns(4)
+	return db
+}
+
+func createTestRepositories(t *testing.T) (*vtubers.Store, *index.IndexedVideoRepository) {
+	t.Helper()
+	ctx := context.Background()
+	db := openTestDB(t)
+
+	store, err := vtubers.CreateStore(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := index.CreateIndexedVideoRepository(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store, repo
+}
+
+func TestTopVTubersByDurationPolicies(t *testing.T) {
+	ctx := context.Background()
+	store, repo := createTestRepositories(t)
+
+	for i, name := range []string{"Owner", "Guest A", "Guest B"} {
+		var v vtubers.VTuber
+		v.ID = i + 1
+		v.YouTubeID = fmt.Sprintf("UC%022d", v.ID)
+		v.EnglishName = name
+		if err := store.CreateOrUpdate(ctx, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	date := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	w := repo.NewBatchWriter(0)
+	defer w.Rollback()
+	write := func(logID int, video logs.VideoInfo, duration time.Duration, vtuberIDs ...int) {
+		t.Helper()
+		if err := w.UpsertVideo(ctx, video); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.InsertVideoHistory(ctx, testUser, video.ID, logID, date, duration); err != nil {
+			t.Fatal(err)
+		}
+		for _, id := range vtuberIDs {
+			if err := w.InsertVideoVTuber(ctx, testUser, video.ID, id, vtubers.SourceNameText); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// A collab on the owner's channel and one on a channel of neither.
+	write(1, logs.VideoInfo{ID: "collab", ChannelID: "UC0000000000000000000001"}, time.Hour, 1, 2, 3)
+	write(2, logs.VideoInfo{ID: "elsewhere", ChannelID: "UC0000000000000000000009"}, 30*time.Minute, 2, 3)
+	if err := w.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		policy index.DurationPolicy
+		want   map[int]time.Duration
+	}{
+		{index.DurationPolicyFull, map[int]time.Duration{1: time.Hour, 2: 90 * time.Minute, 3: 90 * time.Minute}},
+		{index.DurationPolicySplit, map[int]time.Duration{1: time.Hour, 2: 45 * time.Minute, 3: 45 * time.Minute}},
+		{index.DurationPolicyOwnerWeighted, map[int]time.Duration{1: time.Hour, 2: 30 * time.Minute, 3: 30 * time.Minute}},
+	}
+	for _, test := range tests {
+		top, err := repo.GetTopVTubersByDuration(ctx, index.GetTopVTubersParams{
+			UserID: testUser,
+			Start:  date,
+			End:    date,
+			Limit:  10,
+			Policy: test.policy,
+		})
+		if err != nil {
+			t.Fatalf("%s: %s", test.policy, err)
+		}
+		got := make(map[int]time.Duration, len(top))
+		for _, v := range top {
+			got[v.ID] = v.Duration
+		}
+		for id, want := range test.want {
+			if got[id] != want {
+				t.Errorf("%s: expected %d to be credited %s got %s", test.policy, id, want, got[id])
+			}
+		}
+	}
+}
+
+func TestAttributionsWithoutSourceAreCleared(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	if _, err := vtubers.CreateStore(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+
+	// Indexed before the source of attributions was stored.
+	_, err := db.Exec(`
+		CREATE TABLE video_vtubers (
+			video_id TEXT NOT NULL,
+			user_id TEXT NOT NULL,
+			vtuber_id TEXT NOT NULL,
+			PRIMARY KEY (video_id, user_id, vtuber_id)
+		);
+		CREATE TABLE index_cursor (
+			id INTEGER NOT NULL PRIMARY KEY CHECK (id = 0),
+			log_id INTEGER NOT NULL,
+			log_date TIMESTAMP NOT NULL
+		);
+		INSERT INTO video_vtubers VALUES ('video', 'user', 1);
+		INSERT INTO index_cursor VALUES (0, 1, '2024-01-01 00:00:00+00:00');
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo, err := index.CreateIndexedVideoRepository(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cursor, err := repo.GetCursor(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cursor.IsZero() {
+		t.Errorf("Expected the cursor to be cleared got %d", cursor.LogID)
+	}
+	videoIDs, err := repo.GetVideoIDsForVTubers(ctx, []int{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(videoIDs) != 0 {
+		t.Errorf("Expected attributions to be cleared got %v", videoIDs)
+	}
+
+	// Only cleared once.
+	w := repo.NewBatchWriter(0)
+	if err := w.Checkpoint(ctx, index.IndexCursor{LogID: 2, LogDate: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	repo, err = index.CreateIndexedVideoRepository(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cursor, err = repo.GetCursor(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cursor.LogID != 2 {
+		t.Errorf("Expected the cursor to be kept at 2 got %d", cursor.LogID)
+	}
+}
+
+func TestOutdatedIndexIsCleared(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	if _, err := vtubers.CreateStore(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+
+	checkpoint := func(repo *index.IndexedVideoRepository, logID int) {
+		t.Helper()
+		w := repo.NewBatchWriter(0)
+		if err := w.Checkpoint(ctx, index.IndexCursor{LogID: logID, LogDate: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkCursor := func(when string, want int) {
+		t.Helper()
+		repo, err := index.CreateIndexedVideoRepository(ctx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cursor, err := repo.GetCursor(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cursor.LogID != want {
+			t.Errorf("%s: expected the cursor at %d got %d", when, want, cursor.LogID)
+		}
+	}
+
+	repo, err := index.CreateIndexedVideoRepository(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkpoint(repo, 1)
+	checkCursor("current", 1)
+
+	// Indexed by a version attributing detections differently.
+	if _, err := db.Exec("UPDATE index_version SET version = 0"); err != nil {
+		t.Fatal(err)
+	}
+	checkCursor("outdated", 0)
+
+	// Only cleared once.
+	checkpoint(repo, 2)
+	checkCursor("rebuilt", 2)
+}
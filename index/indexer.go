@@ -2,75 +2,314 @@ package index
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"runtime"
+	"slices"
 
 	"github.com/xoltia/botsu-oshi-stats/logs"
 	"github.com/xoltia/botsu-oshi-stats/vtubers"
 )
 
+type IndexOptions struct {
+	// Number of logs written per transaction. Zero or less
+	// writes an entire run in a single transaction.
+	BatchSize int
+	// Number of concurrent detection workers. Defaults to the
+	// number of CPUs.
+	Workers int
+}
+
+func (o *IndexOptions) applyDefaults() {
+	if o.Workers <= 0 {
+		o.Workers = runtime.NumCPU()
+	}
+}
+
 // Indexer reads from the log repository and populates
 // relevant information based on the last update to the
-// vtuber store.
+// vtuber store. Runs must not be made concurrently.
 type Indexer struct {
 	vtuberStore *vtubers.Store
 	logRepo     *logs.UserLogRepository
 	indexRepo   *IndexedVideoRepository
+	options     IndexOptions
+
+	// Kept between runs and reloaded only when the store was updated,
+	// as loading it is much slower than a run with few new logs.
+	detector       *vtubers.Detector
+	detectorUpdate int64
 }
 
 func NewIndexer(
 	vs *vtubers.Store,
 	lr *logs.UserLogRepository,
 	ir *IndexedVideoRepository,
+	options IndexOptions,
 ) *Indexer {
-	return &Indexer{vs, lr, ir}
+	options.applyDefaults()
+	return &Indexer{vtuberStore: vs, logRepo: lr, indexRepo: ir, options: options}
+}
+
+// Rebuild clears the index and indexes every log from the beginning.
+func (i *Indexer) Rebuild(ctx context.Context) error {
+	return i.index(ctx, true)
 }
 
-// TODO: partial reindexing when data not recently updated
+// Index processes all logs added since the last run, as recorded by
+// the persisted index cursor. Beforehand, logs that were deleted or had
+// their duration edited since the last run are reconciled and videos that
+// may be affected by vtuber store updates since the last run are detected
+// again. Logs before the cursor that were committed only after it passed
+// them are indexed as well. Changes are only found without comparing every
+// log once the notification trigger, which keeps a change log, is installed.
 func (i *Indexer) Index(ctx context.Context) error {
-	detector, err := vtubers.CreateDetector(ctx, i.vtuberStore)
+	return i.index(ctx, false)
+}
+
+func (i *Indexer) index(ctx context.Context, rebuild bool) error {
+	detector, err := i.loadDetector(ctx)
+	if err != nil {
+		return fmt.Errorf("load detector: %w", err)
+	}
+
+	overridden, err := i.indexRepo.getOverriddenVideoIDs(ctx)
+	if err != nil {
+		return fmt.Errorf("get overridden videos: %w", err)
+	}
+
+	reconcileStart, err := i.logRepo.Now(ctx)
+	if err != nil {
+		return fmt.Errorf("get log repository time: %w", err)
+	}
+
+	hasChangeLog, err := i.logRepo.HasChangeLog(ctx)
+	if err != nil {
+		return fmt.Errorf("check change log: %w", err)
+	}
+	// Read before any logs, so that changes made while indexing
+	// are left in the change log for the next run.
+	var changes []logs.LogChange
+	if hasChangeLog {
+		changes, err = i.logRepo.GetChanges(ctx)
+		if err != nil {
+			return fmt.Errorf("get changed logs: %w", err)
+		}
+	}
+
+	w := i.indexRepo.NewBatchWriter(i.options.BatchSize)
+	defer w.Rollback()
+
+	var (
+		cursor        IndexCursor
+		appliedUpdate int64
+	)
+	if rebuild {
+		if err := w.Clear(ctx); err != nil {
+			return fmt.Errorf("clear: %w", err)
+		}
+	} else {
+		cursor, err = i.indexRepo.GetCursor(ctx)
+		if err != nil {
+			return fmt.Errorf("get cursor: %w", err)
+		}
+
+		appliedUpdate, err = i.indexRepo.GetAppliedUpdate(ctx)
+		if err != nil {
+			return fmt.Errorf("get applied update: %w", err)
+		}
+	}
+
+	changed, latestUpdate, err := i.vtuberStore.GetChangedSince(ctx, appliedUpdate)
+	if err != nil {
+		return fmt.Errorf("get changed vtubers: %w", err)
+	}
+
+	var missing []int
+	if !cursor.IsZero() {
+		missing, err = i.reconcile(ctx, w, cursor, hasChangeLog, changes)
+		if err != nil {
+			return fmt.Errorf("reconcile: %w", err)
+		}
+	}
+	// Changes made before the change log existed are only
+	// found by comparing every log, until it does.
+	if hasChangeLog {
+		err = w.SetReconciledAt(ctx, reconcileStart)
+	} else {
+		err = w.ClearReconciledAt(ctx)
+	}
 	if err != nil {
+		return fmt.Errorf("set reconciled at: %w", err)
+	}
+
+	// Nothing indexed yet is already up to date with the latest data.
+	if !cursor.IsZero() && len(changed) > 0 {
+		if err := i.reindexChanged(ctx, w, detector, overridden, changed); err != nil {
+			return fmt.Errorf("reindex changed: %w", err)
+		}
+	}
+
+	if latestUpdate != appliedUpdate {
+		if err := w.SetAppliedUpdate(ctx, latestUpdate); err != nil {
+			return fmt.Errorf("set applied update: %w", err)
+		}
+	}
+
+	if err := i.indexMissing(ctx, w, detector, overridden, missing); err != nil {
+		return fmt.Errorf("index missing: %w", err)
+	}
+
+	if err := i.indexNew(ctx, w, detector, overridden, cursor); err != nil {
+		return err
+	}
+
+	if err := w.Commit(); err != nil {
 		return err
 	}
 
-	ls, err := i.logRepo.GetAll(ctx)
+	// Changes that fail to be acknowledged are reconciled again by the
+	// next run, which leaves the index as it is.
+	if err := i.logRepo.AcknowledgeChanges(ctx, changes); err != nil {
+		return fmt.Errorf("acknowledge changes: %w", err)
+	}
+	return nil
+}
+
+// Returns the detector, creating it on first use and reloading it
+// when there has been an update to the vtuber store since.
+func (i *Indexer) loadDetector(ctx context.Context) (*vtubers.Detector, error) {
+	// Read before loading, so that an update made while
+	// loading only causes another reload.
+	updateID, err := i.vtuberStore.LatestUpdateID(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("latest update: %w", err)
+	}
+	if i.detector == nil {
+		detector, err := vtubers.CreateDetector(ctx, i.vtuberStore)
+		if err != nil {
+			return nil, err
+		}
+		i.detector = detector
+	} else if updateID != i.detectorUpdate {
+		if err := i.detector.Reload(ctx); err != nil {
+			return nil, err
+		}
+	}
+	i.detectorUpdate = updateID
+	return i.detector, nil
+}
+
+// IndexNew only processes logs added since the last run, without
+// reconciling existing logs or applying vtuber store updates. Logs
+// committed after a log with a greater ID are left to the next Index.
+func (i *Indexer) IndexNew(ctx context.Context) error {
+	detector, err := i.loadDetector(ctx)
+	if err != nil {
+		return fmt.Errorf("load detector: %w", err)
+	}
+
+	overridden, err := i.indexRepo.getOverriddenVideoIDs(ctx)
+	if err != nil {
+		return fmt.Errorf("get overridden videos: %w", err)
+	}
+
+	cursor, err := i.indexRepo.GetCursor(ctx)
+	if err != nil {
+		return fmt.Errorf("get cursor: %w", err)
+	}
+
+	w := i.indexRepo.NewBatchWriter(i.options.BatchSize)
+	defer w.Rollback()
+
+	if err := i.indexNew(ctx, w, detector, overridden, cursor); err != nil {
+		return err
+	}
+
+	return w.Commit()
+}
+
+func (i *Indexer) indexNew(
+	ctx context.Context,
+	w *BatchWriter,
+	detector *vtubers.Detector,
+	overridden map[string]bool,
+	cursor IndexCursor,
+) error {
+	ls, err := i.logRepo.GetAfter(ctx, cursor.LogID)
 	if err != nil {
 		return err
 	}
 	defer ls.Close()
+	return i.indexLogs(ctx, w, detector, overridden, ls, true)
+}
+
+// Number of logs read at once when indexing logs by ID.
+const missingChunkSize = 1000
 
-	for ls.Next() {
-		log, err := ls.Scan()
+// Indexes logs before the cursor that are not in the index, as logs are not
+// always committed in ID order and may be committed after the cursor passed.
+func (i *Indexer) indexMissing(
+	ctx context.Context,
+	w *BatchWriter,
+	detector *vtubers.Detector,
+	overridden map[string]bool,
+	logIDs []int,
+) error {
+	for chunk := range slices.Chunk(logIDs, missingChunkSize) {
+		ls, err := i.logRepo.GetByIDs(ctx, chunk)
 		if err != nil {
 			return err
 		}
-
-		vs, err := detector.Detect(ctx, log)
+		err = i.indexLogs(ctx, w, detector, overridden, ls, false)
+		ls.Close()
 		if err != nil {
 			return err
 		}
+	}
+	return nil
+}
+
+// Detects and writes every log in the set, advancing the cursor past each
+// log when checkpoint is set. Overrides are applied to the videos in
+// overridden after their detections.
+func (i *Indexer) indexLogs(
+	ctx context.Context,
+	w *BatchWriter,
+	detector *vtubers.Detector,
+	overridden map[string]bool,
+	ls logs.LogSet,
+	checkpoint bool,
+) error {
+	// Writes are made with ctx rather than the detection context, which is
+	// cancelled on return and would roll back a transaction begun with it.
+	detectCtx, cancel := context.WithCancel(ctx)
+	results, wait := detectConcurrently(detectCtx, ls, detector, i.options.Workers)
+	defer func() {
+		cancel()
+		wait()
+	}()
 
-		// Linked channels can be deceiving as they sometimes link to genmates
-		// or otherwise related vtubers. Ignore them for primary sources.
-		var filtered []vtubers.VTuber
-		if vs.PrimaryChannel != nil {
-			filtered = make([]vtubers.VTuber, len(vs.NameText)+1)
-			filtered[0] = *vs.PrimaryChannel
-			copy(filtered[1:], vs.NameText)
-		} else {
-			filtered = vs.All
+	for d := range results {
+		if d.err != nil {
+			return d.err
 		}
+		log, vs := d.log, d.result
 
-		for _, v := range filtered {
-			err := i.indexRepo.InsertVideoVTuber(
+		for _, detection := range vs.Attributed() {
+			err := w.InsertVideoVTuber(
 				ctx,
 				log.UserID,
 				log.Video.ID,
-				v.ID)
+				detection.VTuber.ID,
+				detection.Source)
 			if err != nil {
 				return err
 			}
 		}
 
-		err = i.indexRepo.InsertVideoHistory(
+		err := w.InsertVideoHistory(
 			ctx,
 			log.UserID,
 			log.Video.ID,
@@ -80,10 +319,201 @@ func (i *Indexer) Index(ctx context.Context) error {
 		if err != nil {
 			return err
 		}
+
+		if err := w.UpsertVideo(ctx, log.Video); err != nil {
+			return fmt.Errorf("upsert video: %w", err)
+		}
+
+		// Applied after the history is written, which decides the users they apply to.
+		if overridden[log.Video.ID] {
+			if err := w.ApplyOverrides(ctx, log.Video.ID); err != nil {
+				return fmt.Errorf("apply overrides: %w", err)
+			}
+		}
+
+		if !checkpoint {
+			continue
+		}
+		cursor := IndexCursor{LogID: log.ID, LogDate: log.Date}
+		if err := w.Checkpoint(ctx, cursor); err != nil {
+			return fmt.Errorf("checkpoint: %w", err)
+		}
 	}
 
-	if err := ls.Err(); err != nil {
-		return err
+	// Results end early without an error on cancellation.
+	return ctx.Err()
+}
+
+// Removes video history for logs that were deleted and updates durations
+// that were edited since the last reconcile, up to the given cursor. Returns
+// the IDs of logs up to the cursor that exist but aren't indexed, such as
+// logs committed late or restored after being deleted. The changes are
+// those in the change log, if it exists.
+func (i *Indexer) reconcile(
+	ctx context.Context,
+	w *BatchWriter,
+	cursor IndexCursor,
+	hasChangeLog bool,
+	changes []logs.LogChange,
+) ([]int, error) {
+	reconciledAt, err := i.indexRepo.GetReconciledAt(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get reconciled at: %w", err)
+	}
+	if reconciledAt.IsZero() || !hasChangeLog {
+		return i.reconcileAll(ctx, w, cursor)
+	}
+
+	// Later logs are indexed as they are now.
+	changes = slices.DeleteFunc(slices.Clone(changes), func(c logs.LogChange) bool {
+		return c.ID > cursor.LogID
+	})
+
+	changedIDs := make([]int, 0, len(changes))
+	for _, change := range changes {
+		if !change.Deleted {
+			changedIDs = append(changedIDs, change.ID)
+		}
+	}
+	indexed, err := i.indexRepo.GetIndexedLogIDs(ctx, changedIDs)
+	if err != nil {
+		return nil, fmt.Errorf("get indexed logs: %w", err)
+	}
+
+	var (
+		missing []int
+		deleted bool
+	)
+	for _, change := range changes {
+		if change.Deleted {
+			if err := w.DeleteVideoHistory(ctx, change.ID); err != nil {
+				return nil, fmt.Errorf("delete history: %w", err)
+			}
+			deleted = true
+		} else if !indexed[change.ID] {
+			missing = append(missing, change.ID)
+		} else if err := w.UpdateVideoHistoryDuration(ctx, change.ID, change.Duration); err != nil {
+			return nil, fmt.Errorf("update duration: %w", err)
+		}
+	}
+
+	if deleted {
+		if err := w.DeleteOrphans(ctx); err != nil {
+			return nil, fmt.Errorf("delete orphans: %w", err)
+		}
+	}
+
+	return missing, nil
+}
+
+// Compares every indexed log to the log repository, for when there is no
+// record of what changed since the last reconcile.
+func (i *Indexer) reconcileAll(ctx context.Context, w *BatchWriter, cursor IndexCursor) ([]int, error) {
+	indexed, err := i.indexRepo.GetLogDurations(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get indexed durations: %w", err)
+	}
+
+	current, err := i.logRepo.GetDurations(ctx, cursor.LogID)
+	if err != nil {
+		return nil, fmt.Errorf("get log durations: %w", err)
+	}
+
+	var missing []int
+	for _, log := range current {
+		duration, ok := indexed[log.ID]
+		if !ok {
+			missing = append(missing, log.ID)
+			continue
+		}
+		delete(indexed, log.ID)
+		if duration == log.Duration {
+			continue
+		}
+		if err := w.UpdateVideoHistoryDuration(ctx, log.ID, log.Duration); err != nil {
+			return nil, fmt.Errorf("update duration: %w", err)
+		}
+	}
+
+	// Anything left over was deleted.
+	for logID := range indexed {
+		if err := w.DeleteVideoHistory(ctx, logID); err != nil {
+			return nil, fmt.Errorf("delete history: %w", err)
+		}
+	}
+
+	if len(indexed) > 0 {
+		if err := w.DeleteOrphans(ctx); err != nil {
+			return nil, fmt.Errorf("delete orphans: %w", err)
+		}
+	}
+
+	return missing, nil
+}
+
+// Runs detection again on indexed videos that are either attributed to or
+// could be attributed to any of the changed vtubers, rewriting their attributions.
+func (i *Indexer) reindexChanged(
+	ctx context.Context,
+	w *BatchWriter,
+	detector *vtubers.Detector,
+	overridden map[string]bool,
+	changedIDs []int,
+) error {
+	var (
+		changed        = make([]vtubers.VTuber, 0, len(changedIDs))
+		changedAliases []vtubers.Alias
+	)
+	for _, id := range changedIDs {
+		v, err := i.vtuberStore.FindByID(ctx, id)
+		// Deleted vtubers can't be detected, but the videos
+		// attributed to them are still detected again.
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("find vtuber %d: %w", id, err)
+		}
+		changed = append(changed, v)
+
+		aliases, err := i.vtuberStore.GetAliasesForVTuber(ctx, id)
+		if err != nil {
+			return fmt.Errorf("get aliases %d: %w", id, err)
+		}
+		changedAliases = append(changedAliases, aliases...)
+	}
+
+	candidates := make(map[string]bool)
+	attributedIDs, err := i.indexRepo.GetVideoIDsForVTubers(ctx, changedIDs)
+	if err != nil {
+		return fmt.Errorf("get attributed videos: %w", err)
+	}
+	for _, id := range attributedIDs {
+		candidates[id] = true
+	}
+
+	videos, err := i.indexRepo.GetVideos(ctx)
+	if err != nil {
+		return fmt.Errorf("get videos: %w", err)
+	}
+
+	// Only the changed vtubers can be detected by this detector, so any
+	// video it finds nothing in keeps its current attributions. Rules are
+	// left out as they only remove detections, which can't add candidates.
+	changedDetector := vtubers.NewDetector(changed, changedAliases, nil)
+	for _, video := range videos {
+		if !candidates[video.ID] && len(changedDetector.Detect(video).All) == 0 {
+			continue
+		}
+
+		detections := detector.Detect(video).Attributed()
+		if err := w.ReplaceVideoVTubers(ctx, video.ID, detections); err != nil {
+			return fmt.Errorf("replace video vtubers: %w", err)
+		}
+		if overridden[video.ID] {
+			if err := w.ApplyOverrides(ctx, video.ID); err != nil {
+				return fmt.Errorf("apply overrides: %w", err)
+			}
+		}
 	}
 
 	return nil
@@ -0,0 +1,581 @@
+package index_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mattn/go-sqlite3"
+	"github.com/xoltia/botsu-oshi-stats/index"
+	"github.com/xoltia/botsu-oshi-stats/logs"
+	"github.com/xoltia/botsu-oshi-stats/vtubers"
+)
+
+// SQLite standing in for the Postgres database of activities. The
+// Postgres functions used by the log repository are defined, and text is
+// returned as bytes, as the Postgres driver returns JSON. Times are
+// formatted as the SQLite driver formats times given as arguments.
+const activitiesDriver = "sqlite3_activities"
+
+func init() {
+	sql.Register(activitiesDriver, activitiesSQLite{})
+}
+
+type activitiesSQLite struct{}
+
+func (activitiesSQLite) Open(name string) (driver.Conn, error) {
+	d := &sqlite3.SQLiteDriver{
+		ConnectHook: func(c *sqlite3.SQLiteConn) error {
+			now := func() string { return time.Now().UTC().Format(sqlite3.SQLiteTimestampFormats[0]) }
+			if err := c.RegisterFunc("now", now, false); err != nil {
+				return err
+			}
+			toRegclass := func(name string) (any, error) {
+				rows, err := c.Query("SELECT name FROM sqlite_master WHERE type = 'table' AND name = ?", []driver.Value{name})
+				if err != nil {
+					return nil, err
+				}
+				defer rows.Close()
+				if err := rows.Next(make([]driver.Value, 1)); err == io.EOF {
+					return nil, nil
+				} else if err != nil {
+					return nil, err
+				}
+				return name, nil
+			}
+			return c.RegisterFunc("to_regclass", toRegclass, false)
+		},
+	}
+	conn, err := d.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return activitiesConn{conn.(*sqlite3.SQLiteConn)}, nil
+}
+
+type activitiesConn struct {
+	*sqlite3.SQLiteConn
+}
+
+func (c activitiesConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	rows, err := c.SQLiteConn.QueryContext(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	return activitiesRows{rows}, nil
+}
+
+type activitiesRows struct {
+	driver.Rows
+}
+
+func (r activitiesRows) Next(dest []driver.Value) error {
+	if err := r.Rows.Next(dest); err != nil {
+		return err
+	}
+	for i, column := range r.Columns() {
+		s, ok := dest[i].(string)
+		if !ok {
+			continue
+		}
+		if column == "now()" {
+			t, err := time.Parse(sqlite3.SQLiteTimestampFormats[0], s)
+			if err != nil {
+				return err
+			}
+			dest[i] = t
+		} else {
+			dest[i] = []byte(s)
+		}
+	}
+	return nil
+}
+
+type testIndexer struct {
+	*index.Indexer
+	store      *vtubers.Store
+	repo       *index.IndexedVideoRepository
+	activities *sqlx.DB
+}
+
+func createTestIndexer(t *testing.T) testIndexer {
+	t.Helper()
+	store, repo := createTestRepositories(t)
+
+	activities, err := sqlx.Open(activitiesDriver, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { activities.Close() })
+	activities.SetMaxOpenConns(1)
+
+	_, err = activities.Exec(`
+		CREATE TABLE activities (
+			id INTEGER PRIMARY KEY,
+			user_id TEXT NOT NULL,
+			date TIMESTAMP NOT NULL,
+			duration INTEGER NOT NULL,
+			media_type TEXT NOT NULL,
+			meta TEXT NOT NULL,
+			deleted_at TIMESTAMP
+		)
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ti := testIndexer{store: store, repo: repo, activities: activities}
+	ti.Indexer = ti.newIndexer(index.IndexOptions{})
+	return ti
+}
+
+// Creates another indexer of the same repositories, sharing only what is persisted.
+func (ti testIndexer) newIndexer(options index.IndexOptions) *index.Indexer {
+	return index.NewIndexer(ti.store, logs.NewRepository(ti.activities), ti.repo, options)
+}
+
+// Creates the change log along with a trigger keeping it as the
+// notification trigger does.
+func (ti testIndexer) installChangeLog(t *testing.T) {
+	t.Helper()
+	_, err := ti.activities.Exec(`
+		CREATE TABLE ` + logs.ChangeLogTable + ` (
+			activity_id INTEGER NOT NULL PRIMARY KEY,
+			changed_at TIMESTAMP NOT NULL
+		);
+
+		CREATE TRIGGER log_inserts AFTER INSERT ON activities
+		WHEN NEW.media_type = 'video'
+		BEGIN
+			INSERT INTO ` + logs.ChangeLogTable + ` VALUES (NEW.id, now())
+			ON CONFLICT DO UPDATE SET changed_at = excluded.changed_at;
+		END;
+
+		CREATE TRIGGER log_updates AFTER UPDATE ON activities
+		WHEN OLD.media_type = 'video' OR NEW.media_type = 'video'
+		BEGIN
+			INSERT INTO ` + logs.ChangeLogTable + ` VALUES (NEW.id, now())
+			ON CONFLICT DO UPDATE SET changed_at = excluded.changed_at;
+		END;
+
+		CREATE TRIGGER log_deletes AFTER DELETE ON activities
+		WHEN OLD.media_type = 'video'
+		BEGIN
+			INSERT INTO ` + logs.ChangeLogTable + ` VALUES (OLD.id, now())
+			ON CONFLICT DO UPDATE SET changed_at = excluded.changed_at;
+		END;
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (ti testIndexer) addLog(t *testing.T, id int, userID string, video logs.VideoInfo) {
+	t.Helper()
+	meta, err := json.Marshal(map[string]any{
+		"platform":        "youtube",
+		"video_id":        video.ID,
+		"video_title":     video.Title,
+		"channel_id":      video.ChannelID,
+		"linked_channels": video.LinkedChannels,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ti.activities.Exec(`
+		INSERT INTO activities (id, user_id, date, duration, media_type, meta)
+		VALUES (?, ?, ?, ?, 'video', ?)
+	`, id, userID, time.Date(2024, 1, 1, 0, id, 0, 0, time.UTC), time.Minute, string(meta))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (ti testIndexer) saveVTuber(t *testing.T, id int, youtubeID, englishName string) {
+	t.Helper()
+	var v vtubers.VTuber
+	v.ID = id
+	v.YouTubeID = youtubeID
+	v.EnglishName = englishName
+	ctx := context.Background()
+	if _, err := ti.store.SaveVTuber(ctx, v); err != nil {
+		t.Fatal(err)
+	}
+	if err := ti.store.LogUpdate(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (ti testIndexer) setOverride(t *testing.T, o index.AttributionOverride) {
+	t.Helper()
+	if err := ti.repo.SetOverride(context.Background(), o); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Checks the vtubers attributed to the video for each user.
+func (ti testIndexer) checkAttributed(t *testing.T, when, videoID string, expected map[string][]int) {
+	t.Helper()
+	for userID, want := range expected {
+		vs, err := ti.repo.GetVTubersForVideo(context.Background(), userID, videoID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make([]int, len(vs))
+		for i, v := range vs {
+			got[i] = v.ID
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, want) {
+			t.Errorf("%s: expected %v attributed for %s got %v", when, want, userID, got)
+		}
+	}
+}
+
+// Checks the IDs of the indexed logs and the index cursor.
+func (ti testIndexer) checkIndexed(t *testing.T, when string, wantCursor int, want ...int) {
+	t.Helper()
+	ctx := context.Background()
+	durations, err := ti.repo.GetLogDurations(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := slices.Sorted(maps.Keys(durations))
+	if !slices.Equal(got, want) {
+		t.Errorf("%s: expected logs %v indexed got %v", when, want, got)
+	}
+	cursor, err := ti.repo.GetCursor(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cursor.LogID != wantCursor {
+		t.Errorf("%s: expected cursor at %d got %d", when, wantCursor, cursor.LogID)
+	}
+}
+
+func channelID(n int) string {
+	return fmt.Sprintf("UC%022d", n)
+}
+
+func TestOverridePrecedence(t *testing.T) {
+	ctx := context.Background()
+	ti := createTestIndexer(t)
+	ti.saveVTuber(t, 1, channelID(1), "Usada Pekora")
+	ti.saveVTuber(t, 2, channelID(2), "Houshou Marine")
+	ti.saveVTuber(t, 3, channelID(3), "Gawr Gura")
+
+	video := logs.VideoInfo{ID: "collab", Title: "Collab with Houshou Marine", ChannelID: channelID(1)}
+	ti.addLog(t, 1, "alice", video)
+	ti.addLog(t, 2, "bob", video)
+	if err := ti.Rebuild(ctx); err != nil {
+		t.Fatal(err)
+	}
+	ti.checkAttributed(t, "detected", video.ID, map[string][]int{
+		"alice": {1, 2},
+		"bob":   {1, 2},
+	})
+
+	ti.setOverride(t, index.AttributionOverride{VideoID: video.ID, VTuberID: 3, Action: index.OverrideAdd})
+	ti.setOverride(t, index.AttributionOverride{VideoID: video.ID, UserID: "alice", VTuberID: 3, Action: index.OverrideRemove})
+	ti.setOverride(t, index.AttributionOverride{VideoID: video.ID, VTuberID: 2, Action: index.OverrideRemove})
+
+	detector, err := vtubers.CreateDetector(ctx, ti.store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ti.repo.ReindexVideo(ctx, detector, video.ID); err != nil {
+		t.Fatal(err)
+	}
+	ti.checkAttributed(t, "overridden", video.ID, map[string][]int{
+		"alice": {1},
+		"bob":   {1, 3},
+	})
+}
+
+func TestOverridesSurviveReindexing(t *testing.T) {
+	ctx := context.Background()
+	ti := createTestIndexer(t)
+	ti.saveVTuber(t, 1, channelID(1), "Usada Pekora")
+	ti.saveVTuber(t, 2, channelID(2), "Houshou Marine")
+
+	video := logs.VideoInfo{ID: "collab", Title: "Collab with Houshou Marine", ChannelID: channelID(1)}
+	ti.addLog(t, 1, "alice", video)
+	ti.addLog(t, 2, "bob", video)
+	ti.setOverride(t, index.AttributionOverride{VideoID: video.ID, VTuberID: 2, Action: index.OverrideRemove})
+	ti.setOverride(t, index.AttributionOverride{VideoID: video.ID, UserID: "bob", VTuberID: 2, Action: index.OverrideAdd})
+
+	expected := map[string][]int{
+		"alice": {1},
+		"bob":   {1, 2},
+	}
+	if err := ti.Index(ctx); err != nil {
+		t.Fatal(err)
+	}
+	ti.checkAttributed(t, "indexed", video.ID, expected)
+
+	// Changing the owner detects the video again.
+	ti.saveVTuber(t, 1, channelID(1), "Pekora")
+	if err := ti.Index(ctx); err != nil {
+		t.Fatal(err)
+	}
+	ti.checkAttributed(t, "reindexed", video.ID, expected)
+
+	if err := ti.Rebuild(ctx); err != nil {
+		t.Fatal(err)
+	}
+	ti.checkAttributed(t, "rebuilt", video.ID, expected)
+}
+
+func TestDeletedVTuberIsUnattributed(t *testing.T) {
+	ctx := context.Background()
+	ti := createTestIndexer(t)
+	ti.saveVTuber(t, 1, channelID(1), "Usada Pekora")
+	ti.saveVTuber(t, 2, channelID(2), "Houshou Marine")
+
+	video := logs.VideoInfo{ID: "collab", Title: "Collab with Houshou Marine", ChannelID: channelID(1)}
+	ti.addLog(t, 1, "alice", video)
+	if err := ti.Index(ctx); err != nil {
+		t.Fatal(err)
+	}
+	ti.checkAttributed(t, "indexed", video.ID, map[string][]int{"alice": {1, 2}})
+
+	if _, err := ti.store.DeleteVTuber(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := ti.store.LogUpdate(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := ti.Index(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Attributions of vtubers that don't exist aren't returned with
+	// the vtubers of a video, so they are looked for directly.
+	videoIDs, err := ti.repo.GetVideoIDsForVTubers(ctx, []int{2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(videoIDs) != 0 {
+		t.Errorf("Expected no videos attributed to the deleted vtuber got %v", videoIDs)
+	}
+	ti.checkAttributed(t, "deleted", video.ID, map[string][]int{"alice": {1}})
+}
+
+func TestLinkedChannelAttribution(t *testing.T) {
+	ctx := context.Background()
+	ti := createTestIndexer(t)
+	ti.saveVTuber(t, 1, channelID(1), "Usada Pekora")
+	ti.saveVTuber(t, 2, channelID(2), "Houshou Marine")
+	ti.saveVTuber(t, 3, channelID(3), "Gawr Gura")
+
+	// Only the linked channel of the vtuber named in the title is attributed.
+	ti.addLog(t, 1, "alice", logs.VideoInfo{
+		ID:             "owned",
+		Title:          "Collab with Houshou Marine",
+		ChannelID:      channelID(1),
+		LinkedChannels: []string{channelID(2), channelID(3)},
+	})
+	ti.addLog(t, 2, "alice", logs.VideoInfo{
+		ID:             "clip",
+		Title:          "Best moments",
+		ChannelID:      channelID(9),
+		LinkedChannels: []string{channelID(3)},
+	})
+	if err := ti.Index(ctx); err != nil {
+		t.Fatal(err)
+	}
+	ti.checkAttributed(t, "owned", "owned", map[string][]int{"alice": {1, 2}})
+	ti.checkAttributed(t, "clip", "clip", map[string][]int{"alice": {3}})
+
+	// Attributed as found by name rather than by the linked channel.
+	date := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for source, want := range map[vtubers.Source][]int{
+		vtubers.SourceNameText:      {2},
+		vtubers.SourceLinkedChannel: {3},
+	} {
+		top, err := ti.repo.GetTopVTubersByAppearenceCount(ctx, index.GetTopVTubersParams{
+			UserID:  "alice",
+			Start:   date,
+			End:     date,
+			Limit:   10,
+			Sources: []vtubers.Source{source},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make([]int, len(top))
+		for i, v := range top {
+			got[i] = v.ID
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("Expected %v attributed by %s got %v", want, source, got)
+		}
+	}
+}
+
+func TestIndexNew(t *testing.T) {
+	ctx := context.Background()
+	ti := createTestIndexer(t)
+	ti.saveVTuber(t, 1, channelID(1), "Usada Pekora")
+
+	video := logs.VideoInfo{ID: "stream", Title: "Usada Pekora", ChannelID: channelID(1)}
+	ti.addLog(t, 1, "alice", video)
+	if err := ti.Index(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every write of the run is made while indexing new logs.
+	ti.addLog(t, 2, "bob", video)
+	if err := ti.IndexNew(ctx); err != nil {
+		t.Fatal(err)
+	}
+	ti.checkIndexed(t, "indexed new", 2, 1, 2)
+	ti.checkAttributed(t, "indexed new", video.ID, map[string][]int{"bob": {1}})
+}
+
+func TestFailedBatchIsRolledBack(t *testing.T) {
+	ctx := context.Background()
+	ti := createTestIndexer(t)
+	ti.saveVTuber(t, 1, channelID(1), "Usada Pekora")
+
+	video := logs.VideoInfo{ID: "stream", Title: "Usada Pekora", ChannelID: channelID(1)}
+	for id, userID := range []string{"alice", "alice", "bob", "alice", "alice"} {
+		ti.addLog(t, id+1, userID, video)
+	}
+	// Fails to be read in the middle of the second batch.
+	_, err := ti.activities.Exec(`UPDATE activities SET meta = '{"platform": "youtube", "video_duration": "long"}' WHERE id = 4`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ti.newIndexer(index.IndexOptions{BatchSize: 2}).Index(ctx); err == nil {
+		t.Fatal("Expected an error reading the log")
+	}
+	ti.checkIndexed(t, "failed", 2, 1, 2)
+	ti.checkAttributed(t, "failed", video.ID, map[string][]int{"alice": {1}, "bob": nil})
+
+	ti.addLog(t, 6, "alice", video)
+	if _, err := ti.activities.Exec("DELETE FROM activities WHERE id = 4"); err != nil {
+		t.Fatal(err)
+	}
+	ti.addLog(t, 4, "alice", video)
+	if err := ti.newIndexer(index.IndexOptions{BatchSize: 2}).Index(ctx); err != nil {
+		t.Fatal(err)
+	}
+	ti.checkIndexed(t, "resumed", 6, 1, 2, 3, 4, 5, 6)
+	ti.checkAttributed(t, "resumed", video.ID, map[string][]int{"alice": {1}, "bob": {1}})
+}
+
+func TestIndexResumesFromCursor(t *testing.T) {
+	ctx := context.Background()
+	ti := createTestIndexer(t)
+	ti.saveVTuber(t, 1, channelID(1), "Usada Pekora")
+
+	video := logs.VideoInfo{ID: "stream", Title: "Usada Pekora", ChannelID: channelID(1)}
+	ti.addLog(t, 1, "alice", video)
+	ti.addLog(t, 2, "alice", video)
+	if err := ti.Index(ctx); err != nil {
+		t.Fatal(err)
+	}
+	ti.checkIndexed(t, "indexed", 2, 1, 2)
+
+	// Only the persisted cursor is shared with the first indexer.
+	ti.addLog(t, 4, "alice", video)
+	if err := ti.newIndexer(index.IndexOptions{}).IndexNew(ctx); err != nil {
+		t.Fatal(err)
+	}
+	ti.checkIndexed(t, "resumed", 4, 1, 2, 4)
+}
+
+func TestIndexLogCommittedBehindCursor(t *testing.T) {
+	ctx := context.Background()
+	ti := createTestIndexer(t)
+	ti.saveVTuber(t, 1, channelID(1), "Usada Pekora")
+
+	video := logs.VideoInfo{ID: "stream", Title: "Usada Pekora", ChannelID: channelID(1)}
+	ti.addLog(t, 1, "alice", video)
+	ti.addLog(t, 3, "alice", video)
+	if err := ti.Index(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Committed after the log with a greater ID was indexed.
+	ti.addLog(t, 2, "bob", video)
+	if err := ti.IndexNew(ctx); err != nil {
+		t.Fatal(err)
+	}
+	ti.checkIndexed(t, "indexed new", 3, 1, 3)
+
+	if err := ti.Index(ctx); err != nil {
+		t.Fatal(err)
+	}
+	ti.checkIndexed(t, "reconciled", 3, 1, 2, 3)
+	ti.checkAttributed(t, "reconciled", video.ID, map[string][]int{"bob": {1}})
+}
+
+func TestReconcileChangeLog(t *testing.T) {
+	ctx := context.Background()
+	ti := createTestIndexer(t)
+	ti.installChangeLog(t)
+	ti.saveVTuber(t, 1, channelID(1), "Usada Pekora")
+
+	video := logs.VideoInfo{ID: "stream", Title: "Usada Pekora", ChannelID: channelID(1)}
+	for id, userID := range []string{"alice", "bob", "carol"} {
+		ti.addLog(t, id+1, userID, video)
+	}
+	if err := ti.Index(ctx); err != nil {
+		t.Fatal(err)
+	}
+	ti.checkIndexed(t, "indexed", 3, 1, 2, 3)
+
+	exec := func(query string) {
+		t.Helper()
+		if _, err := ti.activities.Exec(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+	exec("UPDATE activities SET duration = 1 WHERE id = 1")
+	exec("UPDATE activities SET deleted_at = now() WHERE id = 2")
+	exec("DELETE FROM activities WHERE id = 3")
+	if err := ti.Index(ctx); err != nil {
+		t.Fatal(err)
+	}
+	ti.checkIndexed(t, "changed", 3, 1)
+	durations, err := ti.repo.GetLogDurations(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if durations[1] != 1 {
+		t.Errorf("Expected the duration edited to 1 got %d", durations[1])
+	}
+	ti.checkAttributed(t, "changed", video.ID, map[string][]int{"alice": {1}, "bob": nil})
+
+	// Restored after being deleted, and committed after the cursor passed it.
+	ti.addLog(t, 5, "alice", video)
+	if err := ti.IndexNew(ctx); err != nil {
+		t.Fatal(err)
+	}
+	exec("UPDATE activities SET deleted_at = NULL WHERE id = 2")
+	ti.addLog(t, 4, "dave", video)
+	if err := ti.Index(ctx); err != nil {
+		t.Fatal(err)
+	}
+	ti.checkIndexed(t, "restored", 5, 1, 2, 4, 5)
+	ti.checkAttributed(t, "restored", video.ID, map[string][]int{"bob": {1}, "dave": {1}})
+
+	var pending int
+	if err := ti.activities.Get(&pending, "SELECT count(*) FROM "+logs.ChangeLogTable); err != nil {
+		t.Fatal(err)
+	}
+	if pending != 0 {
+		t.Errorf("Expected every change to be acknowledged got %d left", pending)
+	}
+}
@@ -0,0 +1,155 @@
+package index
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/xoltia/botsu-oshi-stats/vtubers"
+)
+
+// OverrideAction is the correction made by an override.
+type OverrideAction string
+
+const (
+	OverrideAdd    OverrideAction = "add"
+	OverrideRemove OverrideAction = "remove"
+)
+
+// AttributionOverride corrects whether a vtuber is attributed to a video,
+// taking precedence over detection. Overrides for a user take precedence
+// over global ones for the same vtuber and video.
+type AttributionOverride struct {
+	VideoID string `db:"video_id"`
+	// Empty for overrides applying to every user.
+	UserID   string         `db:"user_id"`
+	VTuberID int            `db:"vtuber_id"`
+	Action   OverrideAction `db:"action"`
+}
+
+// SetOverride creates or replaces an override. The attributions of the
+// video are only changed once it is indexed again, see ReindexVideo.
+func (r *IndexedVideoRepository) SetOverride(ctx context.Context, o AttributionOverride) error {
+	switch o.Action {
+	case OverrideAdd, OverrideRemove:
+	default:
+		return fmt.Errorf("unknown override action %q", o.Action)
+	}
+	_, err := r.db.NamedExecContext(ctx, `
+		INSERT INTO attribution_overrides (video_id, user_id, vtuber_id, action)
+		VALUES (:video_id, :user_id, :vtuber_id, :action)
+		ON CONFLICT (video_id, user_id, vtuber_id) DO UPDATE
+		SET action = excluded.action
+	`, o)
+	return err
+}
+
+// DeleteOverride deletes an override, reporting whether it existed.
+func (r *IndexedVideoRepository) DeleteOverride(
+	ctx context.Context,
+	videoID string,
+	userID string,
+	vtuberID int,
+) (bool, error) {
+	res, err := r.db.ExecContext(ctx, `
+		DELETE FROM attribution_overrides
+		WHERE video_id = ? AND user_id = ? AND vtuber_id = ?
+	`, videoID, userID, vtuberID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// GetOverrides returns the overrides of a video, or of every video if the ID is empty.
+func (r *IndexedVideoRepository) GetOverrides(ctx context.Context, videoID string) (overrides []AttributionOverride, err error) {
+	err = r.db.SelectContext(ctx, &overrides, `
+		SELECT * FROM attribution_overrides
+		WHERE ? = '' OR video_id = ?
+		ORDER BY video_id, user_id, vtuber_id
+	`, videoID, videoID)
+	return
+}
+
+// Returns the IDs of all videos with overrides.
+func (r *IndexedVideoRepository) getOverriddenVideoIDs(ctx context.Context) (map[string]bool, error) {
+	var ids []string
+	err := r.db.SelectContext(ctx, &ids, "SELECT DISTINCT video_id FROM attribution_overrides")
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	overridden := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		overridden[id] = true
+	}
+	return overridden, nil
+}
+
+// ReindexVideo detects the vtubers of an indexed video again and applies its
+// overrides, so that changes to overrides take effect without a full index run.
+func (r *IndexedVideoRepository) ReindexVideo(ctx context.Context, detector *vtubers.Detector, videoID string) error {
+	video, err := r.GetVideo(ctx, videoID)
+	if err != nil {
+		return fmt.Errorf("get video: %w", err)
+	}
+
+	w := r.NewBatchWriter(0)
+	defer w.Rollback()
+
+	if err := w.ReplaceVideoVTubers(ctx, videoID, detector.Detect(video).Attributed()); err != nil {
+		return fmt.Errorf("replace video vtubers: %w", err)
+	}
+	if err := w.ApplyOverrides(ctx, videoID); err != nil {
+		return fmt.Errorf("apply overrides: %w", err)
+	}
+
+	return w.Commit()
+}
+
+// Overrides of a video in effect for each user who watched it.
+const effectiveOverrides = `
+	WITH viewers AS (
+		SELECT DISTINCT user_id FROM video_history WHERE video_id = ?
+	),
+	effective AS (
+		SELECT v.user_id, o.vtuber_id, o.action
+		FROM viewers v
+		JOIN attribution_overrides o
+		ON o.video_id = ? AND o.user_id IN ('', v.user_id)
+		WHERE o.user_id != '' OR NOT EXISTS (
+			SELECT 1 FROM attribution_overrides u
+			WHERE u.video_id = o.video_id
+			AND u.vtuber_id = o.vtuber_id
+			AND u.user_id = v.user_id
+		)
+	)
+`
+
+// ApplyOverrides applies the overrides of a video to the attributions
+// of every user who watched it.
+func (w *BatchWriter) ApplyOverrides(ctx context.Context, videoID string) error {
+	err := w.exec(ctx, effectiveOverrides+`
+		DELETE FROM video_vtubers
+		WHERE video_id = ? AND (user_id, vtuber_id) IN (
+			SELECT user_id, vtuber_id FROM effective WHERE action = ?
+		)
+	`, videoID, videoID, videoID, OverrideRemove)
+	if err != nil {
+		return err
+	}
+	return w.exec(ctx, effectiveOverrides+`
+		INSERT INTO video_vtubers (user_id, video_id, vtuber_id, source, confidence)
+		SELECT user_id, ?, vtuber_id, ?, ?
+		FROM effective
+		WHERE action = ?
+		ON CONFLICT (video_id, user_id, vtuber_id) DO UPDATE
+		SET source = excluded.source,
+			confidence = excluded.confidence
+	`,
+		videoID,
+		videoID,
+		videoID,
+		vtubers.SourceOverride.String(),
+		vtubers.SourceOverride.Confidence(),
+		OverrideAdd)
+}
@@ -0,0 +1,118 @@
+package index
+
+import (
+	"context"
+	"sync"
+
+	"github.com/xoltia/botsu-oshi-stats/logs"
+	"github.com/xoltia/botsu-oshi-stats/vtubers"
+)
+
+type detection struct {
+	log    logs.Log
+	result vtubers.DetectionResult
+	err    error
+}
+
+// The methods of logs.LogSet used to read logs.
+type logSource interface {
+	Next() bool
+	Scan() (logs.Log, error)
+	Err() error
+}
+
+type detectionJob struct {
+	log  logs.Log
+	done chan<- detection
+}
+
+// Reads logs from the set and runs detection on them using a pool of workers.
+// Results are sent in the same order as the logs were read, with the first
+// error ending the stream. The returned function waits for all goroutines to
+// exit, which requires either consuming every result or cancelling ctx.
+func detectConcurrently(
+	ctx context.Context,
+	ls logSource,
+	detector *vtubers.Detector,
+	workers int,
+) (<-chan detection, func()) {
+	var wg sync.WaitGroup
+	jobs := make(chan detectionJob)
+	pending := make(chan chan detection, workers*4)
+	results := make(chan detection)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(pending)
+		defer close(jobs)
+
+		for ls.Next() {
+			done := make(chan detection, 1)
+			select {
+			case pending <- done:
+			case <-ctx.Done():
+				return
+			}
+
+			log, err := ls.Scan()
+			if err != nil {
+				done <- detection{err: err}
+				return
+			}
+
+			select {
+			case jobs <- detectionJob{log, done}:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		if err := ls.Err(); err != nil {
+			done := make(chan detection, 1)
+			done <- detection{err: err}
+			select {
+			case pending <- done:
+			case <-ctx.Done():
+			}
+		}
+	}()
+
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				result := detector.Detect(job.log.Video)
+				job.done <- detection{log: job.log, result: result}
+			}
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(results)
+
+		for done := range pending {
+			var d detection
+			select {
+			case d = <-done:
+			case <-ctx.Done():
+				return
+			}
+
+			select {
+			case results <- d:
+			case <-ctx.Done():
+				return
+			}
+
+			if d.err != nil {
+				return
+			}
+		}
+	}()
+
+	return results, wg.Wait
+}
@@ -0,0 +1,147 @@
+package index
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/xoltia/botsu-oshi-stats/logs"
+	"github.com/xoltia/botsu-oshi-stats/vtubers"
+)
+
+// Generates logs with IDs counting up from one, alternating between
+// videos on the channels of the vtubers created by testDetector.
+type testSource struct {
+	// Number of logs, or negative for no end.
+	n int
+	// ID of the log failing to scan, or zero for none.
+	failAt int
+	read   int
+}
+
+func (s *testSource) Next() bool {
+	if s.n >= 0 && s.read >= s.n {
+		return false
+	}
+	s.read++
+	return true
+}
+
+func (s *testSource) Scan() (logs.Log, error) {
+	if s.read == s.failAt {
+		return logs.Log{}, errTestScan
+	}
+	var log logs.Log
+	log.ID = s.read
+	log.Video.ChannelID = testChannelID(s.read%2 + 1)
+	return log, nil
+}
+
+func (s *testSource) Err() error {
+	return nil
+}
+
+var errTestScan = errors.New("scan failed")
+
+func testChannelID(n int) string {
+	return fmt.Sprintf("UC%022d", n)
+}
+
+func testDetector() *vtubers.Detector {
+	vs := make([]vtubers.VTuber, 2)
+	for i := range vs {
+		vs[i].ID = i + 1
+		vs[i].YouTubeID = testChannelID(i + 1)
+	}
+	return vtubers.NewDetector(vs, nil, nil)
+}
+
+func TestDetectConcurrentlyOrder(t *testing.T) {
+	const n = 500
+	ctx, cancel := context.WithCancel(context.Background())
+	results, wait := detectConcurrently(ctx, &testSource{n: n}, testDetector(), 8)
+	defer func() {
+		cancel()
+		wait()
+	}()
+
+	next := 1
+	for d := range results {
+		if d.err != nil {
+			t.Fatal(d.err)
+		}
+		if d.log.ID != next {
+			t.Fatalf("Expected log %d got %d", next, d.log.ID)
+		}
+		if vs := d.result.PrimaryChannel; len(vs) != 1 || vs[0].ID != next%2+1 {
+			t.Fatalf("Expected log %d detected with vtuber %d got %v", next, next%2+1, vs)
+		}
+		next++
+	}
+	if next != n+1 {
+		t.Errorf("Expected %d results got %d", n, next-1)
+	}
+}
+
+func TestDetectConcurrentlyError(t *testing.T) {
+	const failAt = 50
+	ctx, cancel := context.WithCancel(context.Background())
+	results, wait := detectConcurrently(ctx, &testSource{n: 100, failAt: failAt}, testDetector(), 8)
+	defer func() {
+		cancel()
+		wait()
+	}()
+
+	next := 1
+	for d := range results {
+		if d.err != nil {
+			if !errors.Is(d.err, errTestScan) {
+				t.Errorf("Expected scan error got %s", d.err)
+			}
+			if next != failAt {
+				t.Errorf("Expected error after %d logs got it after %d", failAt-1, next-1)
+			}
+			next = -1
+			continue
+		}
+		if next < 0 {
+			t.Fatalf("Expected no results after the error got log %d", d.log.ID)
+		}
+		if d.log.ID != next {
+			t.Fatalf("Expected log %d got %d", next, d.log.ID)
+		}
+		next++
+	}
+	if next >= 0 {
+		t.Error("Expected an error")
+	}
+}
+
+func TestDetectConcurrentlyCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	results, wait := detectConcurrently(ctx, &testSource{n: -1}, testDetector(), 8)
+
+	for range 10 {
+		<-results
+	}
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected every goroutine to exit after cancelling")
+	}
+	for d := range results {
+		if d.err != nil {
+			t.Errorf("Expected no error on cancellation got %s", d.err)
+		}
+	}
+}
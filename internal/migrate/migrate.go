@@ -0,0 +1,29 @@
+// Package migrate contains helpers for evolving SQLite schemas created with
+// CREATE TABLE IF NOT EXISTS, which leaves existing tables untouched.
+package migrate
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// AddColumn adds a column to an existing table unless it is already present,
+// reporting whether it was added. The definition must include a default for
+// NOT NULL columns.
+func AddColumn(ctx context.Context, db *sqlx.DB, table, column, definition string) (bool, error) {
+	var count int
+	err := db.GetContext(ctx, &count, "SELECT count(*) FROM pragma_table_info(?) WHERE name = ?", table, column)
+	if err != nil {
+		return false, fmt.Errorf("table info: %w", err)
+	}
+	if count > 0 {
+		return false, nil
+	}
+	_, err = db.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	if err != nil {
+		return false, fmt.Errorf("add column %s.%s: %w", table, column, err)
+	}
+	return true, nil
+}
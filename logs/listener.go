@@ -0,0 +1,126 @@
+package logs
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// NotifyChannel is the channel notified by the trigger created with
+// InstallNotifyTrigger whenever a video activity changes.
+const NotifyChannel = "oshistats_activities"
+
+// Change is the kind of operation performed on a video activity, as sent
+// in the notification payload.
+type Change string
+
+const (
+	ChangeInsert Change = "INSERT"
+	ChangeUpdate Change = "UPDATE"
+	ChangeDelete Change = "DELETE"
+)
+
+// ChangeLogTable is the table where the trigger created with InstallNotifyTrigger
+// records when each video activity was last inserted, edited or deleted.
+const ChangeLogTable = "oshistats_activity_changes"
+
+// InstallNotifyTrigger creates or replaces the trigger on the activities table
+// that notifies NotifyChannel of changes to video activities, along with the
+// change log it keeps of edited and deleted activities.
+func (r *UserLogRepository) InstallNotifyTrigger(ctx context.Context) error {
+	statements := []string{
+		`
+		CREATE TABLE IF NOT EXISTS ` + ChangeLogTable + ` (
+			activity_id BIGINT NOT NULL PRIMARY KEY,
+			changed_at  TIMESTAMPTZ NOT NULL
+		)
+		`,
+		`
+		CREATE OR REPLACE FUNCTION oshistats_notify_activity() RETURNS trigger AS $$
+		BEGIN
+			IF (TG_OP <> 'INSERT' AND OLD.media_type = 'video') OR
+			   (TG_OP <> 'DELETE' AND NEW.media_type = 'video') THEN
+				INSERT INTO ` + ChangeLogTable + ` (activity_id, changed_at)
+				VALUES (CASE WHEN TG_OP = 'DELETE' THEN OLD.id ELSE NEW.id END, clock_timestamp())
+				ON CONFLICT (activity_id) DO UPDATE
+				SET changed_at = excluded.changed_at;
+				PERFORM pg_notify('` + NotifyChannel + `', TG_OP);
+			END IF;
+			RETURN NULL;
+		END;
+		$$ LANGUAGE plpgsql
+		`,
+		`DROP TRIGGER IF EXISTS oshistats_notify_activity ON activities`,
+		`
+		CREATE TRIGGER oshistats_notify_activity
+		AFTER INSERT OR UPDATE OR DELETE ON activities
+		FOR EACH ROW EXECUTE FUNCTION oshistats_notify_activity()
+		`,
+	}
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range statements {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Listen opens a dedicated connection that listens on NotifyChannel and sends
+// each received change on the returned channel until ctx is cancelled. When the
+// connection fails, it is reopened after retryDelay and errors are reported to
+// onError if not nil. Changes are dropped while the channel buffer is full, so
+// consumers should also poll periodically.
+func Listen(ctx context.Context, connString string, retryDelay time.Duration, onError func(error)) <-chan Change {
+	changes := make(chan Change, 64)
+	go func() {
+		defer close(changes)
+		for {
+			err := listen(ctx, connString, changes)
+			if ctx.Err() != nil {
+				return
+			}
+			if onError != nil {
+				onError(err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(retryDelay):
+			}
+		}
+	}()
+	return changes
+}
+
+func listen(ctx context.Context, connString string, changes chan<- Change) error {
+	conn, err := pgx.Connect(ctx, connString)
+	if err != nil {
+		return fmt.Errorf("connect: %w", err)
+	}
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+NotifyChannel); err != nil {
+		return fmt.Errorf("listen: %w", err)
+	}
+
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return fmt.Errorf("wait: %w", err)
+		}
+		select {
+		case changes <- Change(n.Payload):
+		default:
+		}
+	}
+}
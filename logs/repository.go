@@ -52,6 +52,146 @@ func (r *UserLogRepository) GetAll(ctx context.Context) (logs LogSet, err error)
 	`)
 }
 
+// GetAfter returns all video logs with an ID greater than afterID in ascending ID order.
+// Passing zero returns every log.
+func (r *UserLogRepository) GetAfter(ctx context.Context, afterID int) (logs LogSet, err error) {
+	return r.querySet(ctx, `
+		SELECT id, user_id, date, duration, meta
+		FROM activities
+		WHERE id > $1 AND media_type = 'video' AND meta->>'platform' = 'youtube' AND deleted_at IS NULL
+		ORDER BY id;
+	`, afterID)
+}
+
+// GetByIDs returns the video logs with the given IDs that have not been
+// deleted in ascending ID order.
+func (r *UserLogRepository) GetByIDs(ctx context.Context, ids []int) (logs LogSet, err error) {
+	query, args, err := sqlx.In(`
+		SELECT id, user_id, date, duration, meta
+		FROM activities
+		WHERE id IN (?) AND media_type = 'video' AND meta->>'platform' = 'youtube' AND deleted_at IS NULL
+		ORDER BY id;
+	`, ids)
+	if err != nil {
+		return LogSet{}, err
+	}
+	return r.querySet(ctx, r.db.Rebind(query), args...)
+}
+
+type LogDuration struct {
+	ID       int           `db:"id"`
+	Duration time.Duration `db:"duration"`
+}
+
+// GetDurations returns the ID and duration of every video log that has not
+// been deleted with an ID less than or equal to maxID.
+func (r *UserLogRepository) GetDurations(ctx context.Context, maxID int) ([]LogDuration, error) {
+	rows, err := r.db.QueryxContext(ctx, `
+		SELECT id, duration
+		FROM activities
+		WHERE id <= $1 AND media_type = 'video' AND meta->>'platform' = 'youtube' AND deleted_at IS NULL
+	`, maxID)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	defer rows.Close()
+
+	result := make([]LogDuration, 0)
+	for rows.Next() {
+		var row LogDuration
+		if err := rows.StructScan(&row); err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+		result = append(result, row)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("next: %w", err)
+	}
+
+	return result, nil
+}
+
+// LogChange is the current state of a video log recorded in the change log.
+type LogChange struct {
+	ID       int           `db:"id"`
+	Duration time.Duration `db:"duration"`
+	// Set when the log was deleted or is no longer a YouTube video log.
+	Deleted bool `db:"deleted"`
+	// When the change was recorded, used to acknowledge it.
+	ChangedAt time.Time `db:"changed_at"`
+}
+
+// HasChangeLog reports whether the change log kept by the notification
+// trigger exists, which GetChanges requires.
+func (r *UserLogRepository) HasChangeLog(ctx context.Context) (bool, error) {
+	var exists bool
+	err := r.db.GetContext(ctx, &exists, "SELECT to_regclass($1) IS NOT NULL", ChangeLogTable)
+	return exists, err
+}
+
+// Now returns the current time of the database.
+func (r *UserLogRepository) Now(ctx context.Context) (now time.Time, err error) {
+	err = r.db.GetContext(ctx, &now, "SELECT now()")
+	return
+}
+
+// GetChanges returns the current state of every video log in the change
+// log. Logs that no longer exist are returned as deleted. Changes remain
+// until acknowledged, and only become visible once committed, so none
+// are missed however long their transactions take.
+func (r *UserLogRepository) GetChanges(ctx context.Context) ([]LogChange, error) {
+	result := make([]LogChange, 0)
+	err := r.db.SelectContext(ctx, &result, `
+		SELECT
+			c.activity_id AS id,
+			coalesce(a.duration, 0) AS duration,
+			a.id IS NULL OR
+				a.deleted_at IS NOT NULL OR
+				a.media_type IS DISTINCT FROM 'video' OR
+				a.meta->>'platform' IS DISTINCT FROM 'youtube' AS deleted,
+			c.changed_at
+		FROM `+ChangeLogTable+` c
+		LEFT JOIN activities a
+		ON a.id = c.activity_id
+		ORDER BY c.activity_id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("select: %w", err)
+	}
+	return result, nil
+}
+
+// AcknowledgeChanges removes changes from the change log once they are
+// reflected in the index. Logs changed again since are kept.
+func (r *UserLogRepository) AcknowledgeChanges(ctx context.Context, changes []LogChange) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PreparexContext(ctx, `
+		DELETE FROM `+ChangeLogTable+`
+		WHERE activity_id = $1 AND changed_at = $2
+	`)
+	if err != nil {
+		return fmt.Errorf("prepare: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, change := range changes {
+		if _, err := stmt.ExecContext(ctx, change.ID, change.ChangedAt); err != nil {
+			return fmt.Errorf("delete: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
 type GetRecentUserVideosParams struct {
 	UserID  string
 	Limit   int
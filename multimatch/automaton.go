@@ -0,0 +1,153 @@
+package multimatch
+
+import (
+	"slices"
+)
+
+// Used in place of a state for links that don't exist.
+const noState = ^uint32(0)
+
+// Aho-Corasick automaton with states stored in flat arrays indexed by
+// state number. Transitions are sparse, as most states only have one or
+// two, except for the root which is looked up most often.
+type automaton struct {
+	// Transitions from the root. Zero for labels without a child, as
+	// the root is never a child.
+	rootNext [256]uint32
+	// The transitions of state s are labels[edgeStart[s]:edgeStart[s+1]]
+	// sorted in ascending order, leading to the same range of targets.
+	edgeStart []uint32
+	labels    []byte
+	targets   []uint32
+
+	fail []uint32
+	// Nearest state on the fail chain with outputs, or noState.
+	dictLink []uint32
+	// The patterns ending at state s are outputs[outputStart[s]:outputStart[s+1]].
+	outputStart []uint32
+	outputs     []pattern
+
+	// Most characters of a term following each state, up to 255.
+	// Derived from the transitions, and used to limit fuzzy searches.
+	height []uint8
+}
+
+// Returns the state reached from state by the label.
+func (a *automaton) next(state uint32, label byte) (uint32, bool) {
+	if state == 0 {
+		next := a.rootNext[label]
+		return next, next != 0
+	}
+	start, end := a.edgeStart[state], a.edgeStart[state+1]
+	labels := a.labels[start:end]
+	// Linear search is faster for the few transitions of most states.
+	if len(labels) <= 8 {
+		for i, l := range labels {
+			if l == label {
+				return a.targets[int(start)+i], true
+			}
+		}
+		return 0, false
+	}
+	i, found := slices.BinarySearch(labels, label)
+	if !found {
+		return 0, false
+	}
+	return a.targets[int(start)+i], true
+}
+
+func (a *automaton) stateOutputs(state uint32) []pattern {
+	return a.outputs[a.outputStart[state]:a.outputStart[state+1]]
+}
+
+// Builds the automaton from a trie. The trie is left unchanged
+// apart from the state numbers assigned to its nodes.
+func newAutomaton(root *node, queue *nodeQueue) *automaton {
+	// Number states in breadth first order so that the fail link
+	// of a state is always computed before the state itself.
+	var nodes []*node
+	queue.push(root)
+	for !queue.empty() {
+		n := queue.pop()
+		n.state = uint32(len(nodes))
+		nodes = append(nodes, n)
+		for _, child := range n.children {
+			queue.push(child)
+		}
+	}
+
+	a := &automaton{
+		edgeStart:   make([]uint32, len(nodes)+1),
+		labels:      make([]byte, 0, len(nodes)-1),
+		targets:     make([]uint32, 0, len(nodes)-1),
+		fail:        make([]uint32, len(nodes)),
+		dictLink:    make([]uint32, len(nodes)),
+		outputStart: make([]uint32, len(nodes)+1),
+	}
+	for i, n := range nodes {
+		a.edgeStart[i] = uint32(len(a.labels))
+		a.outputStart[i] = uint32(len(a.outputs))
+		a.labels = append(a.labels, n.labels...)
+		for _, child := range n.children {
+			a.targets = append(a.targets, child.state)
+		}
+		a.outputs = append(a.outputs, n.output...)
+	}
+	a.edgeStart[len(nodes)] = uint32(len(a.labels))
+	a.outputStart[len(nodes)] = uint32(len(a.outputs))
+	for i, label := range root.labels {
+		a.rootNext[label] = root.children[i].state
+	}
+
+	a.dictLink[0] = noState
+	for _, n := range nodes {
+		for i, label := range n.labels {
+			child := n.children[i].state
+			fail := uint32(0)
+			if n != root {
+				// Follow the fail links of the parent until a state
+				// with a transition for the label is found.
+				f := a.fail[n.state]
+				for {
+					if next, ok := a.next(f, label); ok {
+						fail = next
+						break
+					}
+					if f == 0 {
+						break
+					}
+					f = a.fail[f]
+				}
+			}
+			a.fail[child] = fail
+			if len(a.stateOutputs(fail)) > 0 {
+				a.dictLink[child] = fail
+			} else {
+				a.dictLink[child] = a.dictLink[fail]
+			}
+		}
+	}
+
+	a.computeHeights()
+	return a
+}
+
+// Computes the height of each state from its children, which
+// are always numbered after it.
+func (a *automaton) computeHeights() {
+	a.height = make([]uint8, len(a.fail))
+	for s := len(a.fail) - 1; s >= 0; s-- {
+		for i := a.edgeStart[s]; i < a.edgeStart[s+1]; i++ {
+			h := int(a.height[a.targets[i]])
+			// Characters are counted at their first byte.
+			if !isContinuationByte(a.labels[i]) {
+				h++
+			}
+			a.height[s] = uint8(min(max(int(a.height[s]), h), 255))
+		}
+	}
+}
+
+func isContinuationByte(b byte) bool {
+	return b&0xC0 == 0x80
+}
@@ -0,0 +1,153 @@
+package multimatch
+
+import (
+	"math/rand/v2"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+var (
+	syllables = strings.Fields("ka ki ku ke ko sa shi su se so ta chi tsu te to na ni nu ne no ha hi fu he ho ma mi mu me mo ya yu yo ra ri ru re ro wa n")
+	kana      = []rune("あいうえおかきくけこさしすせそたちつてとなにぬねのはひふへほまみむめもやゆよらりるれろわをんアイウエオカキクケコサシスセソタチツテトナニヌネノ")
+	kanji     = []rune("兎田宝鐘白上百鬼大空紫咲天音角巻常闇星街桃鈴猫又戌神湊尾丸雪花獅白銀不知火夏色赤井")
+)
+
+// Generates a dictionary of names similar to those of vtubers, with
+// half written in Japanese and half romanized.
+func benchmarkTerms(n int) [][]byte {
+	r := rand.New(rand.NewPCG(1, 2))
+	terms := make([][]byte, 0, n)
+	for i := range n {
+		var b strings.Builder
+		if i%2 == 0 {
+			for range 2 + r.IntN(2) {
+				b.WriteRune(kanji[r.IntN(len(kanji))])
+			}
+			for range 2 + r.IntN(3) {
+				b.WriteRune(kana[r.IntN(len(kana))])
+			}
+		} else {
+			for word := range 2 {
+				if word > 0 {
+					b.WriteByte(' ')
+				}
+				for range 2 + r.IntN(2) {
+					b.WriteString(syllables[r.IntN(len(syllables))])
+				}
+			}
+		}
+		terms = append(terms, []byte(b.String()))
+	}
+	return terms
+}
+
+// Generates titles containing some of the terms among other text.
+func benchmarkText(terms [][]byte) []byte {
+	r := rand.New(rand.NewPCG(3, 4))
+	var b strings.Builder
+	for range 1000 {
+		b.WriteString("【歌枠】Karaoke stream with ")
+		b.Write(terms[r.IntN(len(terms))])
+		b.WriteString(" and friends! #shorts ")
+		for range 3 {
+			b.WriteRune(kana[r.IntN(len(kana))])
+		}
+		b.WriteByte('\n')
+	}
+	return []byte(b.String())
+}
+
+func buildCompact(terms [][]byte) Matcher {
+	var builder Builder
+	for i, term := range terms {
+		builder.Add(term, i)
+	}
+	return builder.Build()
+}
+
+func TestCompactMatchesDense(t *testing.T) {
+	terms := benchmarkTerms(2000)
+	// Overlapping terms to exercise fail and dictionary links.
+	terms = append(terms, []byte("ka"), []byte("kaka"), []byte("a"), []byte("と"))
+	text := benchmarkText(terms)
+
+	type match struct{ output, end int }
+	var expected, actual []match
+	searchDense(buildDense(terms), text, func(p pattern, end int) {
+		expected = append(expected, match{p.output, end})
+	})
+	compact := buildCompact(terms)
+	compact.scan(text, func(p pattern, end int) bool {
+		actual = append(actual, match{p.output, end})
+		return true
+	})
+
+	if len(expected) == 0 {
+		t.Fatal("Expected matches")
+	}
+	if !slices.Equal(expected, actual) {
+		t.Errorf("Expected %d matches equal to the dense automaton got %d", len(expected), len(actual))
+	}
+}
+
+// Reports the heap memory retained by the value returned from build.
+func reportRetained[T any](b *testing.B, build func() T) {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	v := build()
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	runtime.KeepAlive(v)
+	b.ReportMetric(float64(after.HeapAlloc-before.HeapAlloc), "retained-B")
+}
+
+func BenchmarkBuild(b *testing.B) {
+	terms := benchmarkTerms(5000)
+
+	b.Run("dense", func(b *testing.B) {
+		for b.Loop() {
+			buildDense(terms)
+		}
+		reportRetained(b, func() *denseNode { return buildDense(terms) })
+	})
+
+	b.Run("compact", func(b *testing.B) {
+		for b.Loop() {
+			buildCompact(terms)
+		}
+		reportRetained(b, func() Matcher { return buildCompact(terms) })
+	})
+}
+
+func BenchmarkSearch(b *testing.B) {
+	terms := benchmarkTerms(5000)
+	text := benchmarkText(terms)
+
+	b.Run("dense", func(b *testing.B) {
+		root := buildDense(terms)
+		b.SetBytes(int64(len(text)))
+		for b.Loop() {
+			searchDense(root, text, func(pattern, int) {})
+		}
+	})
+
+	b.Run("compact", func(b *testing.B) {
+		matcher := buildCompact(terms)
+		b.SetBytes(int64(len(text)))
+		for b.Loop() {
+			matcher.scan(text, func(pattern, int) bool { return true })
+		}
+	})
+
+	b.Run("fuzzy", func(b *testing.B) {
+		matcher := buildCompact(terms)
+		opts := FuzzyOptions{MaxDistance: 1, MinLength: 8}
+		b.SetBytes(int64(len(text)))
+		for b.Loop() {
+			matcher.fuzzyMatches(text, opts)
+		}
+	})
+}
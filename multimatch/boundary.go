@@ -0,0 +1,30 @@
+package multimatch
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// Reports whether a match may start or end at byte offset i of text.
+// There is a boundary anywhere except between two word runes.
+func isBoundary(text []byte, i int) bool {
+	if i <= 0 || i >= len(text) {
+		return true
+	}
+	prev, _ := utf8.DecodeLastRune(text[:i])
+	next, _ := utf8.DecodeRune(text[i:])
+	return !isWordRune(prev) || !isWordRune(next)
+}
+
+// Reports whether the rune is part of a word in a script that separates
+// words with spaces. Scripts such as Japanese are written without spaces,
+// so every position between their characters is a boundary.
+func isWordRune(r rune) bool {
+	if r < utf8.RuneSelf {
+		return r == '_' || ('0' <= r && r <= '9') || ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z')
+	}
+	if unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana) || r == 'ー' {
+		return false
+	}
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r)
+}
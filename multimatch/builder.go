@@ -3,21 +3,31 @@ package multimatch
 // Builder allows for building of FSMs for constructing a Matcher.
 // Safe for reuse after calling Build. Should not be copied by value.
 type Builder struct {
+	// Applied to added terms and text searched by built matchers.
+	// Must not be changed after adding terms.
+	Normalization Normalization
+	// Only report matches that start and end on word boundaries, so that
+	// terms are not matched inside of other words. Positions between
+	// characters of scripts written without spaces, such as Japanese,
+	// are always boundaries.
+	WordBoundaries bool
+
 	root  *node
 	queue nodeQueue
 }
 
+// Add adds a term reported as output when found. Terms that are empty
+// after normalization would match everywhere and are ignored.
 func (b *Builder) Add(term []byte, output int) {
+	term = b.Normalization.Apply(term)
+	if len(term) == 0 {
+		return
+	}
 	current := b.ensureRoot()
 	for _, b := range term {
-		next := current.next[b]
-		if next == nil {
-			next = new(node)
-			current.next[b] = next
-		}
-		current = next
+		current = current.ensureChild(b)
 	}
-	current.appendUniqueOutput(output)
+	current.appendUniqueOutput(pattern{output, len(term)})
 }
 
 func (b *Builder) AddString(term string, output int) {
@@ -27,10 +37,13 @@ func (b *Builder) AddString(term string, output int) {
 // Build creates an FSM from the constructed trie and returns it as an immutable Matcher.
 // Resets the current builder for reuse.
 func (b *Builder) Build() Matcher {
-	r := b.ensureRoot()
-	b.buildFSMFromTrie()
+	a := newAutomaton(b.ensureRoot(), &b.queue)
 	b.reset()
-	return Matcher{root: r}
+	return Matcher{
+		automaton:      a,
+		normalization:  b.Normalization,
+		wordBoundaries: b.WordBoundaries,
+	}
 }
 
 func (b *Builder) ensureRoot() *node {
@@ -40,38 +53,6 @@ func (b *Builder) ensureRoot() *node {
 	return b.root
 }
 
-func (b Builder) buildFSMFromTrie() {
-	b.root.failLink = b.root
-	for _, child := range b.root.next {
-		if child == nil {
-			continue
-		}
-		child.failLink = b.root
-		b.queue.push(child)
-	}
-
-	for !b.queue.empty() {
-		node := b.queue.pop()
-		for key, child := range node.next {
-			if child == nil {
-				continue
-			}
-			b.queue.push(child)
-			fail := node.failLink
-			for fail.next[key] == nil && fail != b.root {
-				fail = fail.failLink
-			}
-			child.failLink = fail.next[key]
-			if child.failLink == nil {
-				child.failLink = b.root
-			}
-			for _, o := range child.failLink.output {
-				child.appendUniqueOutput(o)
-			}
-		}
-	}
-}
-
 func (b *Builder) reset() {
 	b.root = nil
 	b.queue.clear()
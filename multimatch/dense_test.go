@@ -0,0 +1,79 @@
+package multimatch
+
+// The original automaton using a dense transition table for every node,
+// kept as a reference for benchmarks.
+
+type denseNode struct {
+	next     [256]*denseNode
+	output   []pattern
+	failLink *denseNode
+}
+
+func buildDense(terms [][]byte) *denseNode {
+	root := new(denseNode)
+	for i, term := range terms {
+		current := root
+		for _, b := range term {
+			next := current.next[b]
+			if next == nil {
+				next = new(denseNode)
+				current.next[b] = next
+			}
+			current = next
+		}
+		current.output = append(current.output, pattern{i, len(term)})
+	}
+
+	root.failLink = root
+	var queue []*denseNode
+	for _, child := range root.next {
+		if child == nil {
+			continue
+		}
+		child.failLink = root
+		queue = append(queue, child)
+	}
+
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		for key, child := range node.next {
+			if child == nil {
+				continue
+			}
+			queue = append(queue, child)
+			fail := node.failLink
+			for fail.next[key] == nil && fail != root {
+				fail = fail.failLink
+			}
+			child.failLink = fail.next[key]
+			if child.failLink == nil {
+				child.failLink = root
+			}
+			child.output = append(child.output, child.failLink.output...)
+		}
+	}
+
+	return root
+}
+
+func searchDense(root *denseNode, text []byte, fn func(p pattern, end int)) {
+	var (
+		pos   = 0
+		state = root
+	)
+	for pos < len(text) {
+		b := text[pos]
+		if state.next[b] != nil {
+			state = state.next[b]
+			pos++
+			for _, p := range state.output {
+				fn(p, pos)
+			}
+		} else if state == root {
+			pos++
+		} else {
+			state = state.failLink
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package multimatch
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Encoding format of a Matcher, incremented on incompatible changes.
+//
+// Version 1 consists of the magic bytes and version followed by:
+//
+//	normalization  byte
+//	flags          byte, bit 0 set for word boundaries
+//	states         uvarint
+//	edges          uvarint
+//	outputs        uvarint
+//	edge counts    uvarint per state
+//	labels         byte per edge
+//	targets        uvarint per edge
+//	fail links     uvarint per state
+//	dict links     uvarint per state, state+1 or 0 for none
+//	output counts  uvarint per state
+//	outputs        varint output and uvarint length per output
+const encodingVersion = 1
+
+var encodingMagic = []byte("mmatch")
+
+var ErrInvalidEncoding = errors.New("multimatch: invalid encoding")
+
+// MarshalBinary encodes the matcher, including its options, in a
+// versioned format that can be decoded with UnmarshalBinary.
+func (m *Matcher) MarshalBinary() ([]byte, error) {
+	a := m.automaton
+	if a == nil {
+		a = &automaton{}
+	}
+	states := len(a.fail)
+
+	data := append([]byte(nil), encodingMagic...)
+	data = binary.AppendUvarint(data, encodingVersion)
+	var flags byte
+	if m.wordBoundaries {
+		flags |= 1
+	}
+	data = append(data, byte(m.normalization), flags)
+	data = binary.AppendUvarint(data, uint64(states))
+	data = binary.AppendUvarint(data, uint64(len(a.labels)))
+	data = binary.AppendUvarint(data, uint64(len(a.outputs)))
+
+	for s := range states {
+		data = binary.AppendUvarint(data, uint64(a.edgeStart[s+1]-a.edgeStart[s]))
+	}
+	data = append(data, a.labels...)
+	for _, target := range a.targets {
+		data = binary.AppendUvarint(data, uint64(target))
+	}
+	for _, fail := range a.fail {
+		data = binary.AppendUvarint(data, uint64(fail))
+	}
+	for _, link := range a.dictLink {
+		data = binary.AppendUvarint(data, uint64(link+1))
+	}
+	for s := range states {
+		data = binary.AppendUvarint(data, uint64(a.outputStart[s+1]-a.outputStart[s]))
+	}
+	for _, p := range a.outputs {
+		data = binary.AppendVarint(data, int64(p.output))
+		data = binary.AppendUvarint(data, uint64(p.length))
+	}
+
+	return data, nil
+}
+
+// UnmarshalBinary replaces the matcher with one encoded by MarshalBinary.
+// Returns ErrInvalidEncoding for malformed data.
+func (m *Matcher) UnmarshalBinary(data []byte) error {
+	d := decoder{data: data}
+	if len(data) < len(encodingMagic) || string(data[:len(encodingMagic)]) != string(encodingMagic) {
+		return ErrInvalidEncoding
+	}
+	d.data = d.data[len(encodingMagic):]
+	if version := d.uvarint(); d.err == nil && version != encodingVersion {
+		return fmt.Errorf("multimatch: unsupported encoding version %d", version)
+	}
+
+	normalization := Normalization(d.byte())
+	flags := d.byte()
+	states := d.count()
+	edges := d.count()
+	outputs := d.count()
+	if d.err != nil {
+		return d.err
+	}
+	if states == 0 {
+		*m = Matcher{normalization: normalization, wordBoundaries: flags&1 != 0}
+		return nil
+	}
+
+	a := &automaton{
+		edgeStart:   make([]uint32, states+1),
+		targets:     make([]uint32, edges),
+		fail:        make([]uint32, states),
+		dictLink:    make([]uint32, states),
+		outputStart: make([]uint32, states+1),
+		outputs:     make([]pattern, outputs),
+	}
+	// Limits are checked so that a decoded automaton never indexes out of range.
+	for s := range states {
+		a.edgeStart[s+1] = a.edgeStart[s] + uint32(d.limit(uint64(edges)-uint64(a.edgeStart[s])))
+	}
+	if int(a.edgeStart[states]) != edges {
+		d.fail()
+	}
+	a.labels = append([]byte(nil), d.bytes(edges)...)
+	for i := range a.targets {
+		a.targets[i] = uint32(d.limit(uint64(states) - 1))
+		// The root is never a target.
+		if a.targets[i] == 0 {
+			d.fail()
+		}
+	}
+	// States are numbered breadth first, so links always lead to a lower
+	// state. Otherwise searching could loop forever.
+	for s := range a.fail {
+		a.fail[s] = uint32(d.limit(uint64(max(s-1, 0))))
+	}
+	for s := range a.dictLink {
+		a.dictLink[s] = uint32(d.limit(uint64(s))) - 1
+	}
+	for s := range states {
+		a.outputStart[s+1] = a.outputStart[s] + uint32(d.limit(uint64(outputs)-uint64(a.outputStart[s])))
+	}
+	if int(a.outputStart[states]) != outputs {
+		d.fail()
+	}
+	for i := range a.outputs {
+		a.outputs[i].output = int(d.varint())
+		a.outputs[i].length = int(d.limit(1 << 31))
+	}
+	if d.err != nil {
+		return d.err
+	}
+
+	// Children are numbered after their parents, and the patterns
+	// of a state are as long as the path leading to it.
+	depth := make([]int, states)
+	for s := range states {
+		for i := a.edgeStart[s]; i < a.edgeStart[s+1]; i++ {
+			if int(a.targets[i]) <= s {
+				return ErrInvalidEncoding
+			}
+			depth[a.targets[i]] = depth[s] + 1
+		}
+		for _, p := range a.stateOutputs(uint32(s)) {
+			if p.length != depth[s] {
+				return ErrInvalidEncoding
+			}
+		}
+	}
+	if len(d.data) > 0 {
+		return ErrInvalidEncoding
+	}
+
+	for i := a.edgeStart[0]; i < a.edgeStart[1]; i++ {
+		a.rootNext[a.labels[i]] = a.targets[i]
+	}
+	a.computeHeights()
+
+	*m = Matcher{
+		automaton:      a,
+		normalization:  normalization,
+		wordBoundaries: flags&1 != 0,
+	}
+	return nil
+}
+
+// Reads values from encoded data, recording the first error
+// and returning zero values after it.
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (d *decoder) fail() {
+	if d.err == nil {
+		d.err = ErrInvalidEncoding
+	}
+	d.data = nil
+}
+
+func (d *decoder) uvarint() uint64 {
+	v, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	v, n := binary.Varint(d.data)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+// Reads a uvarint that must not be greater than max.
+func (d *decoder) limit(max uint64) uint64 {
+	v := d.uvarint()
+	if v > max {
+		d.fail()
+		return 0
+	}
+	return v
+}
+
+// Reads the number of elements in an array, which can't be more
+// than the remaining bytes as each element takes at least one.
+func (d *decoder) count() int {
+	return int(d.limit(uint64(len(d.data))))
+}
+
+func (d *decoder) byte() byte {
+	if len(d.data) < 1 {
+		d.fail()
+		return 0
+	}
+	b := d.data[0]
+	d.data = d.data[1:]
+	return b
+}
+
+func (d *decoder) bytes(n int) []byte {
+	if len(d.data) < n {
+		d.fail()
+		return make([]byte, n)
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b
+}
@@ -0,0 +1,257 @@
+package multimatch
+
+import (
+	"iter"
+	"slices"
+	"unicode/utf8"
+)
+
+// FuzzyOptions controls how approximate matches are searched for.
+type FuzzyOptions struct {
+	// Maximum number of characters inserted, deleted or substituted
+	// between a term and the text it matches.
+	MaxDistance int
+	// Terms with fewer characters than this are only matched exactly.
+	MinLength int
+}
+
+// FuzzyMatch is an approximate occurrence of a term.
+type FuzzyMatch struct {
+	Match
+	// Edit distance between the term and the matched text,
+	// zero for exact matches.
+	Distance int
+}
+
+func (m *Matcher) SearchFuzzyString(text string, opts FuzzyOptions) iter.Seq[FuzzyMatch] {
+	return m.SearchFuzzy([]byte(text), opts)
+}
+
+// SearchFuzzy yields the position of terms found in text within the edit
+// distance allowed by opts, counted in characters of the normalized text.
+// Overlapping matches are resolved in favour of the closest, then the
+// leftmost, then the longest, and the rest are yielded ordered by start
+// position.
+func (m *Matcher) SearchFuzzy(text []byte, opts FuzzyOptions) iter.Seq[FuzzyMatch] {
+	var offsets offsetMap
+	if m.normalization != 0 {
+		text, offsets = m.normalization.apply(text, true)
+	}
+
+	return func(yield func(FuzzyMatch) bool) {
+		matches := m.fuzzyMatches(text, opts)
+		slices.SortFunc(matches, func(a, b FuzzyMatch) int {
+			if a.Distance != b.Distance {
+				return a.Distance - b.Distance
+			}
+			if a.Start != b.Start {
+				return a.Start - b.Start
+			}
+			return b.End - a.End
+		})
+
+		var taken []FuzzyMatch
+		for _, match := range matches {
+			overlaps := slices.ContainsFunc(taken, func(t FuzzyMatch) bool {
+				return match.Start < t.End && t.Start < match.End
+			})
+			if !overlaps {
+				taken = append(taken, match)
+			}
+		}
+		slices.SortFunc(taken, func(a, b FuzzyMatch) int {
+			return a.Start - b.Start
+		})
+
+		for _, match := range taken {
+			match.Start, match.End = offsets.original(match.Start, match.End)
+			if !yield(match) {
+				return
+			}
+		}
+	}
+}
+
+// LongestTerm returns the number of characters in the longest normalized
+// term, up to 255. Fuzzy searches can only find exact matches when it is
+// less than MinLength.
+func (m *Matcher) LongestTerm() int {
+	if m.automaton == nil {
+		return 0
+	}
+	return int(m.automaton.height[0])
+}
+
+// Finds every term within the allowed distance of text starting at each
+// character, by walking the trie from the root with a row of the Levenshtein
+// table for each term prefix. Only the cells within MaxDistance of the
+// diagonal can be within the distance, so rows are limited to that band.
+func (m *Matcher) fuzzyMatches(text []byte, opts FuzzyOptions) []FuzzyMatch {
+	a := m.automaton
+	if a == nil || opts.MaxDistance < 0 {
+		return nil
+	}
+
+	// Byte offsets of each character in text, followed by its length.
+	runes := make([]rune, 0, len(text))
+	offsets := make([]int, 0, len(text)+1)
+	for i, r := range string(text) {
+		runes = append(runes, r)
+		offsets = append(offsets, i)
+	}
+	offsets = append(offsets, len(text))
+
+	s := fuzzySearch{
+		matcher: m,
+		opts:    opts,
+		text:    text,
+		offsets: offsets,
+		width:   2*opts.MaxDistance + 1,
+	}
+	for start := range runes {
+		if m.wordBoundaries && !isBoundary(text, offsets[start]) {
+			continue
+		}
+		s.runes = runes[start:]
+		s.start = start
+		// Cell b of the row for a prefix of d characters holds the distance
+		// to the first d-MaxDistance+b characters of the text.
+		row := s.row(0)
+		for b := range row {
+			row[b] = s.limit()
+			if j := b - opts.MaxDistance; j >= 0 && j <= len(s.runes) {
+				row[b] = j
+			}
+		}
+		s.walk(0, 0, [utf8.UTFMax]byte{}, 0)
+	}
+	return s.matches
+}
+
+type fuzzySearch struct {
+	matcher *Matcher
+	opts    FuzzyOptions
+	text    []byte
+	offsets []int
+	width   int
+
+	// Text from the current start character.
+	runes []rune
+	start int
+	// Rows of the table for each prefix length, reused between starts.
+	rows    [][]int
+	matches []FuzzyMatch
+}
+
+// Returns a value greater than any distance that can be matched.
+func (s *fuzzySearch) limit() int {
+	return s.opts.MaxDistance + 1
+}
+
+func (s *fuzzySearch) row(depth int) []int {
+	for len(s.rows) <= depth {
+		s.rows = append(s.rows, make([]int, s.width))
+	}
+	return s.rows[depth]
+}
+
+// Visits the children of state, which is depth characters from the root
+// plus the first n bytes of an incomplete character in pending.
+func (s *fuzzySearch) walk(state uint32, depth int, pending [utf8.UTFMax]byte, n int) {
+	a := s.matcher.automaton
+	for i := a.edgeStart[state]; i < a.edgeStart[state+1]; i++ {
+		next := a.targets[i]
+		pending[n] = a.labels[i]
+		if !utf8.FullRune(pending[:n+1]) && n+1 < utf8.UTFMax {
+			s.walk(next, depth, pending, n+1)
+			continue
+		}
+		r, _ := utf8.DecodeRune(pending[:n+1])
+		if !s.step(depth, r) {
+			continue
+		}
+		// Terms shorter than MinLength only match exactly, so when every
+		// term below is short only the prefix of the text is followed.
+		short := depth+1+int(a.height[next]) < s.opts.MinLength
+		if short && s.row(depth + 1)[s.opts.MaxDistance] != 0 {
+			continue
+		}
+		s.report(next, depth+1)
+		s.walk(next, depth+1, pending, 0)
+	}
+}
+
+// Computes the row for the prefix extended by r from the row at depth,
+// reporting whether any cell is within the distance.
+func (s *fuzzySearch) step(depth int, r rune) bool {
+	var (
+		prev   = s.row(depth)
+		row    = s.row(depth + 1)
+		limit  = s.limit()
+		within = false
+	)
+	for b := range row {
+		// Characters of text covered by the cell.
+		j := depth + 1 - s.opts.MaxDistance + b
+		if j < 0 || j > len(s.runes) {
+			row[b] = limit
+			continue
+		}
+		// Deleting r from the term, inserting text[j-1] or substituting it for r.
+		cost := limit
+		if b+1 < len(prev) {
+			cost = prev[b+1] + 1
+		}
+		if b > 0 {
+			cost = min(cost, row[b-1]+1)
+		}
+		if j > 0 {
+			substitution := prev[b]
+			if s.runes[j-1] != r {
+				substitution++
+			}
+			cost = min(cost, substitution)
+		}
+		row[b] = min(cost, limit)
+		within = within || row[b] < limit
+	}
+	return within
+}
+
+// Records matches of the terms ending at state, which is depth characters
+// from the root. Of the ends within the distance the closest is used, then
+// the shortest, so that following punctuation isn't substituted into a match.
+func (s *fuzzySearch) report(state uint32, depth int) {
+	outputs := s.matcher.automaton.stateOutputs(state)
+	if len(outputs) == 0 {
+		return
+	}
+	var (
+		row     = s.row(depth)
+		best    = s.limit()
+		bestEnd = -1
+	)
+	for b, distance := range row {
+		if distance >= best || (distance > 0 && depth < s.opts.MinLength) {
+			continue
+		}
+		j := depth - s.opts.MaxDistance + b
+		if j == 0 {
+			continue
+		}
+		start, end := s.offsets[s.start], s.offsets[s.start+j]
+		if !s.matcher.accept(s.text, start, end) {
+			continue
+		}
+		best, bestEnd = distance, end
+	}
+	if bestEnd < 0 {
+		return
+	}
+	for _, p := range outputs {
+		s.matches = append(s.matches, FuzzyMatch{
+			Match:    Match{p.output, s.offsets[s.start], bestEnd},
+			Distance: best,
+		})
+	}
+}
@@ -2,37 +2,147 @@ package multimatch
 
 import (
 	"iter"
+	"slices"
 )
 
 type Matcher struct {
-	root *node
+	automaton      *automaton
+	normalization  Normalization
+	wordBoundaries bool
 }
 
 func (m *Matcher) SearchString(text string) iter.Seq[int] {
 	return m.Search([]byte(text))
 }
 
+// Search yields the output of every term found in text. An output is only
+// yielded once per position, even if several of its terms end there.
 func (m *Matcher) Search(text []byte) iter.Seq[int] {
+	text = m.normalization.Apply(text)
 	return func(yield func(int) bool) {
 		var (
-			pos   = 0
-			state = m.root
+			lastEnd int
+			yielded []int
 		)
-		for pos < len(text) {
-			b := text[pos]
-			if state.next[b] != nil {
-				state = state.next[b]
-				for _, o := range state.output {
-					if !yield(o) {
+		m.scan(text, func(p pattern, end int) bool {
+			if end != lastEnd {
+				lastEnd = end
+				yielded = yielded[:0]
+			}
+			if slices.Contains(yielded, p.output) {
+				return true
+			}
+			yielded = append(yielded, p.output)
+			return yield(p.output)
+		})
+	}
+}
+
+// Match is a single occurrence of a term.
+type Match struct {
+	Output int
+	// Byte offsets of the match in the searched text. When normalization
+	// changes the text these cover the original characters that matched.
+	Start, End int
+}
+
+type MatchMode int
+
+const (
+	// Every occurrence of every term, including overlapping ones,
+	// ordered by end position.
+	MatchAll MatchMode = iota
+	// Non-overlapping occurrences, ordered by start position. At each
+	// position the longest term is chosen, and terms overlapping it
+	// are skipped.
+	MatchLeftmostLongest
+)
+
+func (m *Matcher) SearchMatchesString(text string, mode MatchMode) iter.Seq[Match] {
+	return m.SearchMatches([]byte(text), mode)
+}
+
+// SearchMatches yields the position of terms found in text.
+func (m *Matcher) SearchMatches(text []byte, mode MatchMode) iter.Seq[Match] {
+	var offsets offsetMap
+	if m.normalization != 0 {
+		text, offsets = m.normalization.apply(text, true)
+	}
+
+	toOriginal := func(match Match) Match {
+		match.Start, match.End = offsets.original(match.Start, match.End)
+		return match
+	}
+
+	if mode == MatchLeftmostLongest {
+		return func(yield func(Match) bool) {
+			var matches []Match
+			m.scan(text, func(p pattern, end int) bool {
+				matches = append(matches, Match{p.output, end - p.length, end})
+				return true
+			})
+			slices.SortStableFunc(matches, func(a, b Match) int {
+				if a.Start != b.Start {
+					return a.Start - b.Start
+				}
+				return b.End - a.End
+			})
+
+			taken := 0
+			for _, match := range matches {
+				if match.Start < taken {
+					continue
+				}
+				taken = match.End
+				if !yield(toOriginal(match)) {
+					return
+				}
+			}
+		}
+	}
+
+	return func(yield func(Match) bool) {
+		m.scan(text, func(p pattern, end int) bool {
+			return yield(toOriginal(Match{p.output, end - p.length, end}))
+		})
+	}
+}
+
+// Calls fn with every accepted pattern found in the normalized text along
+// with the offset it ends at, stopping early if fn returns false.
+func (m *Matcher) scan(text []byte, fn func(p pattern, end int) bool) {
+	a := m.automaton
+	if a == nil {
+		return
+	}
+	var (
+		pos   = 0
+		state = uint32(0)
+	)
+	for pos < len(text) {
+		next, ok := a.next(state, text[pos])
+		if ok {
+			state = next
+			pos++
+			for s := state; s != noState; s = a.dictLink[s] {
+				for _, p := range a.stateOutputs(s) {
+					if !m.accept(text, pos-p.length, pos) {
+						continue
+					}
+					if !fn(p, pos) {
 						return
 					}
 				}
-				pos++
-			} else if state == m.root {
-				pos++
-			} else {
-				state = state.failLink
 			}
+		} else if state == 0 {
+			pos++
+		} else {
+			state = a.fail[state]
 		}
 	}
 }
+
+// Reports whether a match of text[start:end] should be reported.
+func (m *Matcher) accept(text []byte, start, end int) bool {
+	return !m.wordBoundaries || (isBoundary(text, start) && isBoundary(text, end))
+}
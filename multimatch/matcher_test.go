@@ -2,6 +2,7 @@ package multimatch_test
 
 import (
 	"iter"
+	"slices"
 	"testing"
 
 	"github.com/xoltia/botsu-oshi-stats/multimatch"
@@ -30,3 +31,173 @@ func TestMatcher(t *testing.T) {
 		}
 	}
 }
+
+func TestMatcherNormalization(t *testing.T) {
+	builder := multimatch.Builder{Normalization: multimatch.NormalizeAll}
+	builder.AddString("Pekora", 1)
+	builder.AddString("ウサダ・ペコラ", 2)
+	matcher := builder.Build()
+
+	tests := []struct {
+		text string
+		want []int
+	}{
+		{"PEKORA", []int{1}},
+		{"Ｐｅｋｏｒａ", []int{1}},
+		{"うさだぺこら", []int{2}},
+		{"ｳｻﾀﾞ･ﾍﾟｺﾗ", []int{2}},
+		{"peko", nil},
+	}
+	for _, test := range tests {
+		got := slices.Collect(matcher.SearchString(test.text))
+		if !slices.Equal(got, test.want) {
+			t.Errorf("%q: expected %v got %v", test.text, test.want, got)
+		}
+	}
+}
+
+func TestMatcherWordBoundaries(t *testing.T) {
+	builder := multimatch.Builder{WordBoundaries: true}
+	builder.AddString("Ina", 1)
+	builder.AddString("ぺこら", 2)
+	builder.AddString("Mori", 3)
+	matcher := builder.Build()
+
+	tests := []struct {
+		text string
+		want []int
+	}{
+		{"Final boss", nil},
+		{"Ina's stream", []int{1}},
+		{"【Ina】", []int{1}},
+		{"うさだぺこらです", []int{2}},
+		{"Moriぺこら", []int{3, 2}},
+		{"Memories", nil},
+		{"Mori_chan", nil},
+	}
+	for _, test := range tests {
+		got := slices.Collect(matcher.SearchString(test.text))
+		if !slices.Equal(got, test.want) {
+			t.Errorf("%q: expected %v got %v", test.text, test.want, got)
+		}
+	}
+}
+
+func TestMatcherSearchMatches(t *testing.T) {
+	builder := multimatch.Builder{Normalization: multimatch.NormalizeAll}
+	builder.AddString("pekora", 1)
+	builder.AddString("usada pekora", 2)
+	builder.AddString("ぺこら", 3)
+	matcher := builder.Build()
+
+	text := "ＵＳＡＤＡ Pekora・ペコラ"
+	all := slices.Collect(matcher.SearchMatchesString(text, multimatch.MatchAll))
+	expectedAll := []multimatch.Match{
+		{Output: 2, Start: 0, End: 22},
+		{Output: 1, Start: 16, End: 22},
+		{Output: 3, Start: 25, End: 34},
+	}
+	if !slices.Equal(all, expectedAll) {
+		t.Errorf("Expected all matches %v got %v", expectedAll, all)
+	}
+
+	longest := slices.Collect(matcher.SearchMatchesString(text, multimatch.MatchLeftmostLongest))
+	expectedLongest := []multimatch.Match{
+		{Output: 2, Start: 0, End: 22},
+		{Output: 3, Start: 25, End: 34},
+	}
+	if !slices.Equal(longest, expectedLongest) {
+		t.Errorf("Expected leftmost longest matches %v got %v", expectedLongest, longest)
+	}
+	if got := text[longest[1].Start:longest[1].End]; got != "ペコラ" {
+		t.Errorf("Expected match of ペコラ got %q", got)
+	}
+}
+
+func TestMatcherSearchFuzzy(t *testing.T) {
+	builder := multimatch.Builder{
+		Normalization:  multimatch.NormalizeAll,
+		WordBoundaries: true,
+	}
+	builder.AddString("usada pekora", 0)
+	builder.AddString("houshou marine", 1)
+	builder.AddString("兎田ぺこら", 2)
+	builder.AddString("ina", 3)
+	matcher := builder.Build()
+
+	text := "Usada Pecora with Houshou Marin! 兎田ぺこら ima"
+	matches := slices.Collect(matcher.SearchFuzzyString(text, multimatch.FuzzyOptions{
+		MaxDistance: 1,
+		MinLength:   6,
+	}))
+	expected := []multimatch.FuzzyMatch{
+		{Match: multimatch.Match{Output: 0, Start: 0, End: 12}, Distance: 1},
+		{Match: multimatch.Match{Output: 1, Start: 18, End: 31}, Distance: 1},
+		{Match: multimatch.Match{Output: 2, Start: 33, End: 48}, Distance: 0},
+	}
+	if !slices.Equal(matches, expected) {
+		t.Errorf("Expected fuzzy matches %v got %v", expected, matches)
+	}
+	if n := matcher.LongestTerm(); n != 14 {
+		t.Errorf("Expected longest term of 14 characters got %d", n)
+	}
+}
+
+func TestMatcherIgnoresEmptyTerms(t *testing.T) {
+	builder := multimatch.Builder{
+		Normalization:  multimatch.NormalizeAll,
+		WordBoundaries: true,
+	}
+	builder.AddString("", 0)
+	builder.AddString("・", 1)
+	builder.AddString("Pekora", 2)
+	matcher := builder.Build()
+
+	if got := slices.Collect(matcher.SearchString("hi Pekora")); !slices.Equal(got, []int{2}) {
+		t.Errorf("Expected matches [2] got %v", got)
+	}
+	matches := slices.Collect(matcher.SearchFuzzyString("hi Pekorb", multimatch.FuzzyOptions{
+		MaxDistance: 1,
+		MinLength:   6,
+	}))
+	expected := []multimatch.FuzzyMatch{
+		{Match: multimatch.Match{Output: 2, Start: 3, End: 9}, Distance: 1},
+	}
+	if !slices.Equal(matches, expected) {
+		t.Errorf("Expected fuzzy matches %v got %v", expected, matches)
+	}
+}
+
+func TestMatcherMarshalBinary(t *testing.T) {
+	builder := multimatch.Builder{
+		Normalization:  multimatch.NormalizeAll,
+		WordBoundaries: true,
+	}
+	builder.AddString("Usada Pekora", 1)
+	builder.AddString("Pekora", 1)
+	builder.AddString("ぺこら", 2)
+	builder.AddString("Kora", 3)
+	original := builder.Build()
+
+	data, err := original.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded multimatch.Matcher
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+
+	text := "USADA PEKORA ペコラ Kora"
+	expected := slices.Collect(original.SearchMatchesString(text, multimatch.MatchAll))
+	actual := slices.Collect(decoded.SearchMatchesString(text, multimatch.MatchAll))
+	if len(expected) != 4 || !slices.Equal(expected, actual) {
+		t.Errorf("Expected %v got %v", expected, actual)
+	}
+
+	for i := range data {
+		if err := decoded.UnmarshalBinary(data[:i]); err == nil {
+			t.Errorf("Expected error decoding %d of %d bytes", i, len(data))
+		}
+	}
+}
@@ -4,16 +4,38 @@ import (
 	"slices"
 )
 
+// Trie node used while building. Children are kept sorted by label.
 type node struct {
-	next     [256]*node
-	output   []int
-	failLink *node
+	labels   []byte
+	children []*node
+	output   []pattern
+	// State number assigned when building the automaton.
+	state uint32
 }
 
-func (n *node) appendUniqueOutput(o int) {
-	if !slices.Contains(n.output, o) {
-		n.output = append(n.output, o)
+// A term ending at a node. The same output may end at a node
+// more than once with different lengths.
+type pattern struct {
+	output int
+	length int
+}
+
+func (n *node) appendUniqueOutput(p pattern) {
+	if !slices.Contains(n.output, p) {
+		n.output = append(n.output, p)
+	}
+}
+
+// Returns the child for the label, creating it if it does not exist.
+func (n *node) ensureChild(label byte) *node {
+	i, found := slices.BinarySearch(n.labels, label)
+	if found {
+		return n.children[i]
 	}
+	child := new(node)
+	n.labels = slices.Insert(n.labels, i, label)
+	n.children = slices.Insert(n.children, i, child)
+	return child
 }
 
 type nodeQueue struct {
@@ -0,0 +1,137 @@
+package multimatch
+
+import (
+	"unicode/utf8"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Normalization is a set of transformations applied to both the terms added
+// to a Builder and the text searched by the resulting Matcher, so that
+// equivalent spellings of a term match each other.
+type Normalization uint8
+
+const (
+	// Applies Unicode NFKC normalization, i.e. full-width "Ｐｅｋｏｒａ" to "Pekora".
+	NormalizeNFKC Normalization = 1 << iota
+	// Folds case, i.e. "PEKORA" to "pekora".
+	NormalizeCaseFold
+	// Converts katakana to hiragana, i.e. "ペコラ" to "ぺこら".
+	NormalizeKana
+	// Removes the middle dots used to separate words in Japanese names.
+	NormalizeSeparators
+
+	NormalizeAll = NormalizeNFKC | NormalizeCaseFold | NormalizeKana | NormalizeSeparators
+)
+
+// Apply returns the normalized text. The text is returned as is when
+// no normalization is set, otherwise a new slice is allocated.
+func (n Normalization) Apply(text []byte) []byte {
+	if n == 0 {
+		return text
+	}
+	result, _ := n.apply(text, false)
+	return result
+}
+
+func (n Normalization) ApplyString(text string) string {
+	return string(n.Apply([]byte(text)))
+}
+
+func isSeparator(r rune) bool {
+	// Katakana and half-width katakana middle dots.
+	return r == '・' || r == '･'
+}
+
+// Reports whether the rune is a katakana with a hiragana
+// equivalent 0x60 code points before it.
+func isConvertibleKatakana(r rune) bool {
+	return (r >= 'ァ' && r <= 'ヶ') || r == 'ヽ' || r == 'ヾ'
+}
+
+// Maps byte offsets of normalized text back to the text it was normalized
+// from. A nil map is the identity.
+type offsetMap struct {
+	// Offsets of the start and end of the original segment
+	// that each normalized byte was produced from.
+	starts []int
+	ends   []int
+}
+
+// Returns the range of the original text that produced text[start:end].
+func (m offsetMap) original(start, end int) (int, int) {
+	if m.starts == nil {
+		return start, end
+	}
+	return m.starts[start], m.ends[end-1]
+}
+
+// Normalizes text one segment at a time, optionally recording the segment
+// each output byte was produced from. Segments are single runes, or
+// normalization boundaries when NFKC is used, as a character may be
+// composed of several runes.
+func (n Normalization) apply(text []byte, mapped bool) ([]byte, offsetMap) {
+	var (
+		result  = make([]byte, 0, len(text))
+		offsets offsetMap
+		iter    norm.Iter
+		fold    cases.Caser
+		pos     int
+	)
+	if n&NormalizeNFKC != 0 {
+		iter.Init(norm.NFKC, text)
+	}
+	if n&NormalizeCaseFold != 0 {
+		// Casers are stateful and can't be shared between goroutines.
+		fold = cases.Fold()
+	}
+
+	for pos < len(text) {
+		var (
+			start   = pos
+			segment []byte
+		)
+		if n&NormalizeNFKC != 0 {
+			segment = iter.Next()
+			pos = iter.Pos()
+		} else {
+			_, size := utf8.DecodeRune(text[pos:])
+			segment = text[pos : pos+size]
+			pos += size
+		}
+		if n&NormalizeCaseFold != 0 {
+			segment = fold.Bytes(segment)
+		}
+
+		before := len(result)
+		result = n.appendRunes(result, segment)
+		if mapped {
+			for range len(result) - before {
+				offsets.starts = append(offsets.starts, start)
+				offsets.ends = append(offsets.ends, pos)
+			}
+		}
+	}
+
+	return result, offsets
+}
+
+// Appends the runes of segment to result, applying the per rune normalizations.
+func (n Normalization) appendRunes(result, segment []byte) []byte {
+	if n&(NormalizeKana|NormalizeSeparators) == 0 {
+		return append(result, segment...)
+	}
+	for len(segment) > 0 {
+		r, size := utf8.DecodeRune(segment)
+		segment = segment[size:]
+		switch {
+		case n&NormalizeSeparators != 0 && isSeparator(r):
+			continue
+		case n&NormalizeKana != 0 && isConvertibleKatakana(r):
+			r -= 0x60
+		}
+		result = utf8.AppendRune(result, r)
+	}
+	return result
+}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"database/sql"
@@ -10,6 +11,10 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/xoltia/botsu-oshi-stats/auth"
@@ -20,6 +25,10 @@ import (
 	"github.com/xoltia/botsu-oshi-stats/vtubers"
 )
 
+// Attributions counted in stats, leaving out fuzzy name matches,
+// which are too often wrong to rank vtubers by.
+var statsMinConfidence = vtubers.SourceNameText.Confidence()
+
 type OAuthConfig struct {
 	ClientID     string
 	ClientSecret string
@@ -32,6 +41,12 @@ type ImgproxyConfig struct {
 	Key  []byte
 }
 
+type DebugConfig struct {
+	// Discord IDs of the users allowed to use the debug endpoints,
+	// which are disabled when there are none.
+	UserIDs []string
+}
+
 type Server struct {
 	logRepo        *logs.UserLogRepository
 	indexRepo      *index.IndexedVideoRepository
@@ -39,6 +54,11 @@ type Server struct {
 	sessions       *auth.SessionStore
 	oauthConfig    OAuthConfig
 	imgproxyConfig ImgproxyConfig
+	debugConfig    DebugConfig
+
+	detectorMu     sync.Mutex
+	detector       *vtubers.Detector
+	detectorUpdate int64
 }
 
 func NewServer(
@@ -48,6 +68,7 @@ func NewServer(
 	sessionStore *auth.SessionStore,
 	oauthConfig OAuthConfig,
 	imgproxyConfig ImgproxyConfig,
+	debugConfig DebugConfig,
 ) *Server {
 	return &Server{
 		logRepo:        logRepo,
@@ -56,7 +77,35 @@ func NewServer(
 		sessions:       sessionStore,
 		oauthConfig:    oauthConfig,
 		imgproxyConfig: imgproxyConfig,
+		debugConfig:    debugConfig,
+	}
+}
+
+// Returns the detector shared by requests, creating it on first use and
+// reloading it when there has been an update to the vtuber store since.
+func (s *Server) loadDetector(ctx context.Context) (*vtubers.Detector, error) {
+	s.detectorMu.Lock()
+	defer s.detectorMu.Unlock()
+
+	// Read before loading, so that an update made while
+	// loading only causes another reload.
+	updateID, err := s.vtuberRepo.LatestUpdateID(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("latest update: %w", err)
+	}
+	if s.detector == nil {
+		detector, err := vtubers.CreateDetector(ctx, s.vtuberRepo)
+		if err != nil {
+			return nil, err
+		}
+		s.detector = detector
+	} else if updateID != s.detectorUpdate {
+		if err := s.detector.Reload(ctx); err != nil {
+			return nil, err
+		}
 	}
+	s.detectorUpdate = updateID
+	return s.detector, nil
 }
 
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -70,6 +119,8 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	mux.HandleFunc("GET /{$}", authHandler.WrapHandlerFunc(s.getIndex))
 	mux.HandleFunc("GET /logs", authHandler.WrapHandlerFunc(s.getLogs))
 	mux.HandleFunc("GET /overview", authHandler.WrapHandlerFunc(s.getOverview))
+	mux.HandleFunc("GET /debug/detect", authHandler.WrapHandlerFunc(s.getDebugDetect))
+	mux.HandleFunc("POST /videos/{video}/overrides", authHandler.WrapHandlerFunc(s.postVideoOverride))
 	mux.HandleFunc("GET /auth/callback", authHandler.HandleCallback)
 	mux.Handle("GET /static/", http.StripPrefix("/static/", http.FileServerFS(static.FS)))
 	mux.ServeHTTP(w, r)
@@ -80,9 +131,14 @@ func (s *Server) getOverview(w http.ResponseWriter, r *http.Request) {
 	if timelineType != "week" && timelineType != "all" {
 		timelineType = "all"
 	}
+	policy := index.DurationPolicy(r.URL.Query().Get("policy"))
+	if policy != index.DurationPolicyFull && policy != index.DurationPolicySplit {
+		policy = index.DurationPolicyOwnerWeighted
+	}
 	session := auth.MustSessionFromContext(r.Context())
 	model := components.TimelinePageModel{
 		Type:                  timelineType,
+		Policy:                string(policy),
 		UserProfilePictureURL: avatarURL(session),
 	}
 
@@ -116,7 +172,13 @@ func (s *Server) getOverview(w http.ResponseWriter, r *http.Request) {
 		model.Timeline.Values = append(model.Timeline.Values, int(h.Duration.Minutes()))
 	}
 
-	topVTubers, err := s.indexRepo.GetTopVTubersByAppearenceCount(r.Context(), session.UserID, start, end, 10)
+	topVTubers, err := s.indexRepo.GetTopVTubersByAppearenceCount(r.Context(), index.GetTopVTubersParams{
+		UserID:        session.UserID,
+		Start:         start,
+		End:           end,
+		MinConfidence: statsMinConfidence,
+		Limit:         10,
+	})
 	if err != nil {
 		log.Printf("Error getting top vtubers: %s", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -141,7 +203,14 @@ func (s *Server) getOverview(w http.ResponseWriter, r *http.Request) {
 			Appearances: v.Appearances,
 		})
 	}
-	topVTubersDuration, err := s.indexRepo.GetTopVTubersByDuration(r.Context(), session.UserID, start, end, 10)
+	topVTubersDuration, err := s.indexRepo.GetTopVTubersByDuration(r.Context(), index.GetTopVTubersParams{
+		UserID:        session.UserID,
+		Start:         start,
+		End:           end,
+		MinConfidence: statsMinConfidence,
+		Limit:         10,
+		Policy:        policy,
+	})
 	if err != nil {
 		log.Printf("Error getting top vtubers by duration: %s", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -205,15 +274,9 @@ func (s *Server) getIndex(w http.ResponseWriter, r *http.Request) {
 		video.ChannelTitle = vid.ChannelName
 		video.PercentWatched = min(1, float64(watchTime)/float64(vid.Duration))
 		video.ThumbnailURL = s.getImgproxyURL(vid.ThumbnailURL, "format:webp", "width:500")
+		video.ID = vid.ID
 		video.URL = fmt.Sprintf("https://youtu.be/%s", vid.ID)
-		video.VTubers = make([]components.WatchedVideoVTuber, len(vtubers))
-
-		for i, vtuber := range vtubers {
-			video.VTubers[i] = components.WatchedVideoVTuber{
-				OshiMark: vtuber.OshiMark,
-				Name:     vtuber.EnglishName,
-			}
-		}
+		video.VTubers = watchedVideoVTubers(vtubers)
 
 		videos = append(videos, video)
 	}
@@ -222,13 +285,12 @@ func (s *Server) getIndex(w http.ResponseWriter, r *http.Request) {
 	// TODO: implement better ranking
 	const topVTubersNumber = 6
 	topVTubersModel := make([]components.TopVTuber, 0, topVTubersNumber)
-	topVTubers, err := s.indexRepo.GetTopVTubersByAppearenceCount(
-		r.Context(),
-		userID,
-		time.Time{},
-		time.Now(),
-		topVTubersNumber,
-	)
+	topVTubers, err := s.indexRepo.GetTopVTubersByAppearenceCount(r.Context(), index.GetTopVTubersParams{
+		UserID:        userID,
+		End:           time.Now(),
+		MinConfidence: statsMinConfidence,
+		Limit:         topVTubersNumber,
+	})
 	if err != nil {
 		log.Printf("get top vtubers: %s", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -254,13 +316,13 @@ func (s *Server) getIndex(w http.ResponseWriter, r *http.Request) {
 	end := time.Now()
 	start := end.AddDate(0, 0, -6)
 	topVTubersModelWeek := make([]components.TopVTuber, 0, topVTubersNumber)
-	topVTubersWeek, err := s.indexRepo.GetTopVTubersByAppearenceCount(
-		r.Context(),
-		userID,
-		start,
-		end,
-		topVTubersNumber,
-	)
+	topVTubersWeek, err := s.indexRepo.GetTopVTubersByAppearenceCount(r.Context(), index.GetTopVTubersParams{
+		UserID:        userID,
+		Start:         start,
+		End:           end,
+		MinConfidence: statsMinConfidence,
+		Limit:         topVTubersNumber,
+	})
 	if err != nil {
 		log.Printf("get top vtubers: %s", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -338,15 +400,9 @@ func (s *Server) getLogs(w http.ResponseWriter, r *http.Request) {
 		video.ChannelTitle = vid.ChannelName
 		video.PercentWatched = min(1, float64(watchTime)/float64(vid.Duration))
 		video.ThumbnailURL = s.getImgproxyURL(vid.ThumbnailURL, "format:webp", "width:500")
+		video.ID = vid.ID
 		video.URL = fmt.Sprintf("https://youtu.be/%s", vid.ID)
-		video.VTubers = make([]components.WatchedVideoVTuber, len(vtubers))
-
-		for i, vtuber := range vtubers {
-			video.VTubers[i] = components.WatchedVideoVTuber{
-				OshiMark: vtuber.OshiMark,
-				Name:     vtuber.EnglishName,
-			}
-		}
+		video.VTubers = watchedVideoVTubers(vtubers)
 
 		videos = append(videos, video)
 	}
@@ -355,6 +411,152 @@ func (s *Server) getLogs(w http.ResponseWriter, r *http.Request) {
 	components.WatchedVideoGridElements(videos, continuationURL).Render(r.Context(), w)
 }
 
+// Explains the vtubers detected in an indexed video given by the video parameter,
+// or in the video described by the title, channel and linked parameters.
+// Parameters given along with a video replace its metadata. Only available
+// to the users in the debug config.
+func (s *Server) getDebugDetect(w http.ResponseWriter, r *http.Request) {
+	session := auth.MustSessionFromContext(r.Context())
+	if !slices.Contains(s.debugConfig.UserIDs, session.UserID) {
+		http.NotFound(w, r)
+		return
+	}
+
+	query := r.URL.Query()
+
+	var video logs.VideoInfo
+	if id := query.Get("video"); id != "" {
+		var err error
+		video, err = s.indexRepo.GetVideo(r.Context(), id)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "video not indexed", http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Printf("get video error: %s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+	if query.Has("title") {
+		video.Title = query.Get("title")
+	}
+	if query.Has("channel") {
+		video.ChannelID = query.Get("channel")
+	}
+	if query.Has("linked") {
+		video.LinkedChannels = query["linked"]
+	}
+
+	detector, err := s.loadDetector(r.Context())
+	if err != nil {
+		log.Printf("load detector error: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	detector.Explain(video).WriteText(w)
+}
+
+func watchedVideoVTubers(vs []vtubers.VTuber) []components.WatchedVideoVTuber {
+	result := make([]components.WatchedVideoVTuber, len(vs))
+	for i, vtuber := range vs {
+		result[i] = components.WatchedVideoVTuber{
+			ID:       vtuber.ID,
+			OshiMark: vtuber.OshiMark,
+			Name:     vtuber.EnglishName,
+		}
+	}
+	return result
+}
+
+// Corrects the vtubers attributed to a video for the user, either removing
+// the vtuber given by ID or adding the one given by name or handle. Responds
+// with the updated vtubers of the video.
+func (s *Server) postVideoOverride(w http.ResponseWriter, r *http.Request) {
+	// Other sites can't set the header without a preflight request, which
+	// protects sessions whose cookie was created before it was SameSite.
+	if r.Header.Get("HX-Request") != "true" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	userID := auth.MustSessionFromContext(r.Context()).UserID
+	videoID := r.PathValue("video")
+
+	var (
+		message  string
+		override = index.AttributionOverride{
+			VideoID: videoID,
+			UserID:  userID,
+			Action:  index.OverrideAction(r.FormValue("action")),
+		}
+	)
+	switch override.Action {
+	case index.OverrideRemove:
+		vtuberID, err := strconv.Atoi(r.FormValue("vtuber"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		override.VTuberID = vtuberID
+	case index.OverrideAdd:
+		name := strings.TrimSpace(r.FormValue("name"))
+		var (
+			vtuber vtubers.VTuber
+			err    error
+		)
+		if strings.HasPrefix(name, "@") {
+			vtuber, err = s.vtuberRepo.FindByYouTubeHandle(r.Context(), name)
+		} else {
+			vtuber, err = s.vtuberRepo.FindByName(r.Context(), name)
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			message = fmt.Sprintf("No vtuber named %s", name)
+		} else if err != nil {
+			log.Printf("find vtuber error: %s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		override.VTuberID = vtuber.ID
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if message == "" {
+		if err := s.indexRepo.SetOverride(r.Context(), override); err != nil {
+			log.Printf("set override error: %s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		detector, err := s.loadDetector(r.Context())
+		if err != nil {
+			log.Printf("load detector error: %s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		err = s.indexRepo.ReindexVideo(r.Context(), detector, videoID)
+		if errors.Is(err, sql.ErrNoRows) {
+			// Applied once the video is indexed.
+			message = "Saved, the video hasn't been indexed yet"
+		} else if err != nil {
+			log.Printf("reindex video error: %s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	vs, err := s.indexRepo.GetVTubersForVideo(r.Context(), userID, videoID)
+	if err != nil {
+		log.Printf("get video vtubers error: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	components.WatchedVideoVTubers(videoID, watchedVideoVTubers(vs), message).Render(r.Context(), w)
+}
+
 func avatarURL(session auth.Session) string {
 	if session.Avatar == "" {
 		return ""
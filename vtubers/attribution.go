@@ -0,0 +1,43 @@
+package vtubers
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	// Text between title brackets, i.e. 【Game】Title【Name / 名前】.
+	titleBracketRegex = regexp.MustCompile(`【([^】]*)】|\[([^\]]*)\]`)
+	// Names credited as guests, i.e. "w/ Name" or "ft. Name", up to the next bracket or separator.
+	titleCreditRegex = regexp.MustCompile(`(?i)(?:^|[\s(（])(?:w/|ft\.|feat\.|featuring)\s*([^【】\[\]()（）|｜]+)`)
+	titleHandleRegex = regexp.MustCompile(`@[\p{L}\p{N}_.\-]+`)
+	// Separators between names in a credit or bracket.
+	titleNameSeparatorRegex = regexp.MustCompile(`\s*(?:[/／,，、&＆+＋×]|\s[xX]\s)\s*`)
+)
+
+// Returns the names and handles that a title explicitly attributes. Handles
+// keep their leading '@'. Names are not checked against any vtuber, so most
+// bracket contents such as game titles are returned as well.
+func titleAttributions(title string) []string {
+	var names []string
+	addSegment := func(segment string) {
+		for _, name := range titleNameSeparatorRegex.Split(segment, -1) {
+			name = strings.TrimSpace(name)
+			if name != "" && !strings.HasPrefix(name, "@") {
+				names = append(names, name)
+			}
+		}
+	}
+
+	for _, match := range titleBracketRegex.FindAllStringSubmatch(title, -1) {
+		addSegment(match[1] + match[2])
+	}
+	for _, match := range titleCreditRegex.FindAllStringSubmatch(title, -1) {
+		addSegment(match[1])
+	}
+	for _, handle := range titleHandleRegex.FindAllString(title, -1) {
+		names = append(names, strings.TrimRight(handle, ".-"))
+	}
+
+	return names
+}
@@ -4,44 +4,298 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"slices"
 	"strings"
+	"sync/atomic"
+	"unicode/utf8"
 
 	"github.com/xoltia/botsu-oshi-stats/logs"
 	"github.com/xoltia/botsu-oshi-stats/multimatch"
 )
 
+// Detector finds vtubers in video metadata using lookup tables held in
+// memory. Safe for concurrent use, including with Reload.
 type Detector struct {
+	store  *Store
+	tables atomic.Pointer[lookupTables]
+}
+
+// Applied to names before searching or looking them up so that
+// width, case, kana and separator variants are equivalent.
+const nameNormalization = multimatch.NormalizeAll
+
+type lookupTables struct {
 	dictionary multimatch.Matcher
-	store      *Store
+	// Whether any name is long enough to be matched approximately,
+	// as searching for near misses is much slower than for names.
+	fuzzy bool
+	byID  map[int]VTuber
+	// Channels may be shared by multiple vtubers.
+	byYouTubeID map[string][]VTuber
+	// Keyed by lower-cased handle.
+	byHandle map[string]VTuber
+	// Keyed by lower-cased hashtag. Tags shared between
+	// vtubers are left out as they can't identify one.
+	byHashtag map[string]VTuber
+	// Keyed by normalized full name, without any filtering
+	// for short names. Ambiguous names are left out.
+	byName map[string]VTuber
+	// Rules applied to name matches, keyed by vtuber ID.
+	rules map[int][]compiledRule
 }
 
-func CreateDetector(ctx context.Context, s *Store) (*Detector, error) {
-	names, err := s.GetAllNames(ctx)
+// Builds the lookup tables, using the dictionary if given
+// instead of building one from the names.
+// Rules which fail to compile are skipped.
+func newLookupTables(
+	vs []VTuber,
+	aliases []Alias,
+	rules []Rule,
+	dictionary *multimatch.Matcher,
+) *lookupTables {
+	t := &lookupTables{
+		byID:        make(map[int]VTuber, len(vs)),
+		byYouTubeID: make(map[string][]VTuber, len(vs)),
+		byHandle:    make(map[string]VTuber, len(vs)),
+		byHashtag:   make(map[string]VTuber, len(vs)),
+		byName:      make(map[string]VTuber, len(vs)*2),
+		rules:       make(map[int][]compiledRule),
+	}
+	sharedHashtags := make(map[string]bool)
+	sharedNames := make(map[string]bool)
+	for _, v := range vs {
+		for _, tag := range strings.Fields(v.Hashtags) {
+			tag = strings.ToLower(tag)
+			if other, ok := t.byHashtag[tag]; ok && other.ID != v.ID {
+				sharedHashtags[tag] = true
+			}
+			t.byHashtag[tag] = v
+		}
+		for _, name := range []string{v.OriginalName, v.EnglishName} {
+			name = nameNormalization.ApplyString(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+			if other, ok := t.byName[name]; ok && other.ID != v.ID {
+				sharedNames[name] = true
+			}
+			t.byName[name] = v
+		}
+		t.byID[v.ID] = v
+		if v.YouTubeID != "" {
+			t.byYouTubeID[v.YouTubeID] = append(t.byYouTubeID[v.YouTubeID], v)
+		}
+		if v.YouTubeHandle != "" {
+			t.byHandle[strings.ToLower(v.YouTubeHandle)] = v
+		}
+	}
+	for _, a := range aliases {
+		v, ok := t.byID[a.VTuberID]
+		if !ok {
+			continue
+		}
+		name := nameNormalization.ApplyString(strings.TrimSpace(a.Alias))
+		if other, ok := t.byName[name]; ok && other.ID != v.ID {
+			sharedNames[name] = true
+		}
+		t.byName[name] = v
+	}
+	for tag := range sharedHashtags {
+		delete(t.byHashtag, tag)
+	}
+	for name := range sharedNames {
+		delete(t.byName, name)
+	}
+	for _, r := range rules {
+		compiled, err := compileRule(r)
+		if err != nil {
+			continue
+		}
+		t.rules[r.VTuberID] = append(t.rules[r.VTuberID], compiled)
+	}
+	if dictionary != nil {
+		t.dictionary = *dictionary
+	} else {
+		t.dictionary = buildDictionary(vs, aliases)
+	}
+	t.fuzzy = t.dictionary.LongestTerm() >= fuzzyNameOptions.MinLength
+	return t
+}
+
+// Only names long enough that a single typo is unlikely
+// to turn another word into them are matched approximately.
+var fuzzyNameOptions = multimatch.FuzzyOptions{
+	MaxDistance: 1,
+	MinLength:   8,
+}
+
+// Incremented when changes to buildDictionary or the multimatch
+// options it uses invalidate stored dictionaries.
+const dictionaryVersion = 3
+
+// Builds the dictionary of names searched for in titles.
+func buildDictionary(vs []VTuber, aliases []Alias) multimatch.Matcher {
+	builder := multimatch.Builder{
+		Normalization:  nameNormalization,
+		WordBoundaries: true,
+	}
+	ids := make(map[int]bool, len(vs))
+	for _, v := range vs {
+		ids[v.ID] = true
+		addNames(&builder, Names{
+			ID:           v.ID,
+			OriginalName: v.OriginalName,
+			EnglishName:  v.EnglishName,
+		})
+	}
+	for _, a := range aliases {
+		if ids[a.VTuberID] {
+			addAlias(&builder, a)
+		}
+	}
+	return builder.Build()
+}
+
+// RebuildDictionary builds the name dictionary from the current contents of
+// the store and saves it, so that detectors can be created without building it.
+func (s *Store) RebuildDictionary(ctx context.Context) error {
+	updateID, err := s.LatestUpdateID(ctx)
+	if err != nil {
+		return fmt.Errorf("latest update: %w", err)
+	}
+	vs, err := s.GetAll(ctx)
+	if err != nil {
+		return err
+	}
+	aliases, err := s.GetAliases(ctx)
+	if err != nil {
+		return err
+	}
+
+	dictionary := buildDictionary(vs, aliases)
+	data, err := dictionary.MarshalBinary()
 	if err != nil {
+		return fmt.Errorf("marshal: %w", err)
+	}
+
+	return s.SaveDictionary(ctx, StoredDictionary{
+		Version:  dictionaryVersion,
+		UpdateID: updateID,
+		Data:     data,
+	})
+}
+
+// Returns the stored dictionary if it is up to date with the latest update,
+// or nil if it must be rebuilt.
+func (s *Store) loadDictionary(ctx context.Context) (*multimatch.Matcher, error) {
+	updateID, err := s.LatestUpdateID(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("latest update: %w", err)
+	}
+	stored, err := s.GetDictionary(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
 		return nil, err
 	}
+	if stored.Version != dictionaryVersion || stored.UpdateID != updateID {
+		return nil, nil
+	}
 
-	builder := multimatch.Builder{}
-	for _, entry := range names {
-		if acceptableOriginalName(entry.OriginalName) {
-			cleanName := strings.Replace(entry.OriginalName, "・", "", -1)
-			builder.AddString(cleanName, entry.ID)
-		}
-		if acceptableEnglishName(entry.EnglishName) {
-			builder.AddString(entry.EnglishName, entry.ID)
-		}
+	var dictionary multimatch.Matcher
+	if err := dictionary.UnmarshalBinary(stored.Data); err != nil {
+		return nil, fmt.Errorf("unmarshal: %w", err)
+	}
+	return &dictionary, nil
+}
+
+// CreateDetector creates a detector using all vtubers, aliases and rules in the store.
+func CreateDetector(ctx context.Context, s *Store) (*Detector, error) {
+	d := &Detector{store: s}
+	if err := d.Reload(ctx); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// NewDetector creates a detector for a fixed set of vtubers that cannot be reloaded.
+// Aliases and rules of vtubers not in the set are ignored, as are rules that
+// fail Rule.Validate.
+func NewDetector(vs []VTuber, aliases []Alias, rules []Rule) *Detector {
+	d := &Detector{}
+	d.tables.Store(newLookupTables(vs, aliases, rules, nil))
+	return d
+}
+
+// Reload replaces the lookup tables with the current contents of the store.
+// The stored dictionary is used when it is up to date. Fails without
+// replacing them if any stored rule is invalid.
+func (d *Detector) Reload(ctx context.Context) error {
+	if d.store == nil {
+		return errors.New("detector has no store")
+	}
+	// Loaded first so that a concurrent update can only make
+	// it out of date, and never newer than the vtubers.
+	dictionary, err := d.store.loadDictionary(ctx)
+	if err != nil {
+		return fmt.Errorf("load dictionary: %w", err)
+	}
+	vs, err := d.store.GetAll(ctx)
+	if err != nil {
+		return err
+	}
+	aliases, err := d.store.GetAliases(ctx)
+	if err != nil {
+		return err
+	}
+	rules, err := d.store.GetRules(ctx)
+	if err != nil {
+		return err
+	}
+	// Skipping them would silently detect what they were added to prevent.
+	if err := validateRules(rules); err != nil {
+		return fmt.Errorf("invalid rules: %w", err)
 	}
+	d.tables.Store(newLookupTables(vs, aliases, rules, dictionary))
+	return nil
+}
 
-	matcher := builder.Build()
-	return &Detector{
-		dictionary: matcher,
-		store:      s,
-	}, nil
+func addNames(builder *multimatch.Builder, entry Names) {
+	if acceptableOriginalName(entry.OriginalName) {
+		builder.AddString(entry.OriginalName, entry.ID)
+	}
+	if acceptableEnglishName(entry.EnglishName) {
+		builder.AddString(entry.EnglishName, entry.ID)
+	}
+}
+
+func addAlias(builder *multimatch.Builder, a Alias) {
+	var acceptable bool
+	switch a.Filter {
+	case AliasFilterOriginal:
+		acceptable = acceptableOriginalName(a.Alias)
+	case AliasFilterEnglish:
+		acceptable = acceptableEnglishAlias(a.Alias)
+	case AliasFilterNone:
+		acceptable = nameNormalization.ApplyString(a.Alias) != ""
+	}
+	if acceptable {
+		builder.AddString(a.Alias, a.VTuberID)
+	}
 }
 
 // Filter for English names that are too likely to have false positives.
+// Names are only matched as whole words, but very short single words are
+// still likely to be abbreviations or common words.
 func acceptableEnglishName(s string) bool {
+	return strings.IndexByte(s, ' ') != -1 || utf8.RuneCountInString(s) >= 3
+}
+
+// Filter for English aliases. Single word nicknames are often common
+// words, such as "Boss" or "Ghost", which canonical names rarely are,
+// so only aliases of more than one word are accepted.
+func acceptableEnglishAlias(s string) bool {
 	return strings.IndexByte(s, ' ') != -1
 }
 
@@ -57,21 +311,160 @@ func acceptableOriginalName(s string) bool {
 	return (hasNonKana && len(runes) > 1) || len(runes) >= 5
 }
 
+// Source is the method by which a vtuber was detected.
+type Source int
+
+const (
+	SourcePrimaryChannel Source = iota
+	SourceLinkedChannel
+	SourceTitleAttribution
+	SourceHashtag
+	SourceNameText
+	SourceFuzzyName
+	// Attributed by hand rather than detected.
+	SourceOverride
+)
+
+func (s Source) String() string {
+	switch s {
+	case SourcePrimaryChannel:
+		return "primary_channel"
+	case SourceLinkedChannel:
+		return "linked_channel"
+	case SourceTitleAttribution:
+		return "title_attribution"
+	case SourceHashtag:
+		return "hashtag"
+	case SourceNameText:
+		return "name_text"
+	case SourceFuzzyName:
+		return "fuzzy_name"
+	case SourceOverride:
+		return "override"
+	default:
+		return "unknown"
+	}
+}
+
+// Confidence is an estimate between 0 and 1 of how likely a detection
+// from the source is to be correct.
+func (s Source) Confidence() float64 {
+	switch s {
+	case SourcePrimaryChannel:
+		return 1
+	case SourceLinkedChannel:
+		return 0.6
+	case SourceTitleAttribution:
+		return 0.8
+	case SourceHashtag:
+		return 0.7
+	case SourceNameText:
+		return 0.5
+	case SourceFuzzyName:
+		return 0.3
+	case SourceOverride:
+		return 1
+	default:
+		return 0
+	}
+}
+
 // DetectionResult contains the result of searching for hints of a vtubers
 // presence from video metadata. A single vtuber is only detected once per
 // type of detection, with more significant methods being attempted first.
-// The complete order being: Primary Channel > Linked Channel > Name Search.
+// The complete order being: Primary Channel > Linked Channel > Title Attribution >
+// Hashtag > Name Search > Fuzzy Name Search.
 type DetectionResult struct {
 	// All VTubers detected.
 	All []VTuber
-	// A single vtuber instance that matches the video uploader's channel ID.
-	PrimaryChannel *VTuber
+	// All vtubers using the video uploader's channel. More than one
+	// when the channel is shared, such as for groups and units.
+	PrimaryChannel []VTuber
 	// All channels linked in the YouTube description using handles or links.
 	LinkedChannel []VTuber
-	// Names attributes through well formated titles.
-	// TitleAttribution []VTuber
+	// Names attributed through well formatted titles.
+	TitleAttribution []VTuber
+	// Official hashtags used in the title.
+	Hashtag []VTuber
 	// Names found anywhere else in the video text.
 	NameText []VTuber
+	// Long names found in the title with a typo or different spelling.
+	FuzzyName []VTuber
+	// Positions of all names found in the title, including names of
+	// vtubers detected by more significant methods.
+	NameMatches []NameMatch
+}
+
+// NameMatch is an occurrence of a vtuber's name or alias in a video title.
+type NameMatch struct {
+	VTuber VTuber
+	// Byte offsets of the name in the title.
+	Start, End int
+	// Number of characters differing from the name, zero for exact matches.
+	Distance int
+}
+
+// Detection is a single vtuber found in a video along with how it was found.
+type Detection struct {
+	VTuber VTuber
+	Source Source
+}
+
+// Detections returns every detected vtuber in the same order as All,
+// labelled with the category it was detected in.
+func (r DetectionResult) Detections() []Detection {
+	detections := make([]Detection, 0, len(r.All))
+	categories := []struct {
+		vtubers []VTuber
+		source  Source
+	}{
+		{r.PrimaryChannel, SourcePrimaryChannel},
+		{r.LinkedChannel, SourceLinkedChannel},
+		{r.TitleAttribution, SourceTitleAttribution},
+		{r.Hashtag, SourceHashtag},
+		{r.NameText, SourceNameText},
+		{r.FuzzyName, SourceFuzzyName},
+	}
+	for _, c := range categories {
+		for _, v := range c.vtubers {
+			detections = append(detections, Detection{v, c.source})
+		}
+	}
+	return detections
+}
+
+// Attributed returns the detections that should be attributed to the
+// video. Linked channels can be deceiving as they sometimes link to
+// genmates or otherwise related vtubers, so they are left out when the
+// uploader's channel belongs to a vtuber, unless the vtuber's name was
+// also found in the title.
+func (r DetectionResult) Attributed() []Detection {
+	detections := r.Detections()
+	if len(r.PrimaryChannel) == 0 {
+		return detections
+	}
+	attributed := detections[:0]
+	for _, d := range detections {
+		if d.Source == SourceLinkedChannel {
+			i := slices.IndexFunc(r.NameMatches, func(m NameMatch) bool {
+				return m.VTuber.ID == d.VTuber.ID
+			})
+			if i < 0 {
+				continue
+			}
+			d.Source = SourceNameText
+			if r.NameMatches[i].Distance > 0 {
+				d.Source = SourceFuzzyName
+			}
+		}
+		attributed = append(attributed, d)
+	}
+	return attributed
+}
+
+// SharedChannel reports whether the uploader's channel belongs to more than one vtuber.
+func (r DetectionResult) SharedChannel() bool {
+	return len(r.PrimaryChannel) > 1
 }
 
 func appendUnique(slice []VTuber, element VTuber) []VTuber {
@@ -85,46 +478,114 @@ func appendUnique(slice []VTuber, element VTuber) []VTuber {
 	}
 }
 
-func (d *Detector) Detect(ctx context.Context, log logs.Log) (DetectionResult, error) {
+func (d *Detector) Detect(video logs.VideoInfo) DetectionResult {
+	return d.detect(video, nil)
+}
+
+// Detects vtubers in the video, recording every hint found
+// in the explanation if it isn't nil.
+func (d *Detector) detect(video logs.VideoInfo, e *Explanation) DetectionResult {
+	t := d.tables.Load()
 	result := DetectionResult{}
-	// TODO: Fix this, vtubers can share channels
-	vtuber, err := d.store.FindByYouTubeID(ctx, log.Video.ChannelID)
-	if err != nil && !errors.Is(err, sql.ErrNoRows) {
-		return DetectionResult{}, err
-	} else if err == nil {
+	for _, vtuber := range t.byYouTubeID[video.ChannelID] {
 		result.All = append(result.All, vtuber)
-		result.PrimaryChannel = &result.All[0]
+		e.add(vtuber, SourcePrimaryChannel, video.ChannelID, 0)
 	}
+	primaryEnd := len(result.All)
 
-	linkedStart := len(result.All)
-	for _, link := range log.Video.LinkedChannels {
+	for _, link := range video.LinkedChannels {
 		if strings.HasPrefix(link, "UC") {
-			vtuber, err = d.store.FindByYouTubeID(ctx, link)
+			for _, vtuber := range t.byYouTubeID[link] {
+				result.All = appendUnique(result.All, vtuber)
+				e.add(vtuber, SourceLinkedChannel, link, 0)
+			}
 		} else if strings.HasPrefix(link, "@") {
-			vtuber, err = d.store.FindByYouTubeHandle(ctx, link)
-		}
-		if err != nil && !errors.Is(err, sql.ErrNoRows) {
-			return DetectionResult{}, err
-		} else if err == nil {
-			result.All = appendUnique(result.All, vtuber)
+			if vtuber, ok := t.byHandle[strings.ToLower(link)]; ok {
+				result.All = appendUnique(result.All, vtuber)
+				e.add(vtuber, SourceLinkedChannel, link, 0)
+			}
 		}
 	}
 	// First index after end of linked channels subslice.
 	linkedEnd := len(result.All)
 
-	ids := d.dictionary.SearchString(log.Video.Title)
-	for id := range ids {
-		vtuber, err := d.store.FindByID(ctx, id)
-		if err != nil && !errors.Is(err, sql.ErrNoRows) {
-			return DetectionResult{}, err
-		} else if err == nil {
+	for _, name := range titleAttributions(video.Title) {
+		var (
+			vtuber VTuber
+			ok     bool
+		)
+		if strings.HasPrefix(name, "@") {
+			vtuber, ok = t.byHandle[strings.ToLower(name)]
+		} else {
+			vtuber, ok = t.byName[nameNormalization.ApplyString(name)]
+		}
+		if ok {
+			result.All = appendUnique(result.All, vtuber)
+			e.add(vtuber, SourceTitleAttribution, name, 0)
+		}
+	}
+	titleEnd := len(result.All)
+
+	for _, original := range hashtagRegex.FindAllString(video.Title, -1) {
+		tag := strings.ToLower(normalizeHashtag(original))
+		if vtuber, ok := t.byHashtag[tag]; ok {
 			result.All = appendUnique(result.All, vtuber)
+			e.add(vtuber, SourceHashtag, original, 0)
 		}
 	}
+	hashtagEnd := len(result.All)
 
-	// TODO: Also check hashtags
+	// Overlapping names are skipped so a name found inside of a longer
+	// name of another vtuber isn't attributed.
+	matches := t.dictionary.SearchMatchesString(video.Title, multimatch.MatchLeftmostLongest)
+	for match := range matches {
+		vtuber, ok := t.byID[match.Output]
+		if !ok {
+			continue
+		}
+		nameMatch := NameMatch{vtuber, match.Start, match.End, 0}
+		if rule, rejected := rejectingRule(t.rules[vtuber.ID], video.Title, match.Start, match.End); rejected {
+			e.reject(nameMatch, rule)
+			continue
+		}
+		result.All = appendUnique(result.All, vtuber)
+		result.NameMatches = append(result.NameMatches, nameMatch)
+		e.add(vtuber, SourceNameText, video.Title[match.Start:match.End], 0)
+	}
+	nameEnd := len(result.All)
+
+	// Skipped when no name is long enough to be matched approximately.
+	if t.fuzzy {
+		// Exact matches were found above, and near misses overlapping
+		// them are likely part of the same name.
+		exactMatches := result.NameMatches
+		for match := range t.dictionary.SearchFuzzyString(video.Title, fuzzyNameOptions) {
+			overlaps := slices.ContainsFunc(exactMatches, func(m NameMatch) bool {
+				return match.Start < m.End && m.Start < match.End
+			})
+			if match.Distance == 0 || overlaps {
+				continue
+			}
+			vtuber, ok := t.byID[match.Output]
+			if !ok {
+				continue
+			}
+			nameMatch := NameMatch{vtuber, match.Start, match.End, match.Distance}
+			if rule, rejected := rejectingRule(t.rules[vtuber.ID], video.Title, match.Start, match.End); rejected {
+				e.reject(nameMatch, rule)
+				continue
+			}
+			result.All = appendUnique(result.All, vtuber)
+			result.NameMatches = append(result.NameMatches, nameMatch)
+			e.add(vtuber, SourceFuzzyName, video.Title[match.Start:match.End], match.Distance)
+		}
+	}
 
-	result.LinkedChannel = result.All[linkedStart:linkedEnd]
-	result.NameText = result.All[linkedEnd:]
-	return result, nil
+	result.PrimaryChannel = result.All[:primaryEnd]
+	result.LinkedChannel = result.All[primaryEnd:linkedEnd]
+	result.TitleAttribution = result.All[linkedEnd:titleEnd]
+	result.Hashtag = result.All[titleEnd:hashtagEnd]
+	result.NameText = result.All[hashtagEnd:nameEnd]
+	result.FuzzyName = result.All[nameEnd:]
+	return result
 }
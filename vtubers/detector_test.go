@@ -0,0 +1,411 @@
+package vtubers_test
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/xoltia/botsu-oshi-stats/logs"
+	"github.com/xoltia/botsu-oshi-stats/vtubers"
+)
+
+func testVTuber(id int, youtubeID, handle, originalName, englishName string) vtubers.VTuber {
+	return vtubers.VTuber{
+		VTuberRendered: vtubers.VTuberRendered{
+			YouTubeID:     youtubeID,
+			YouTubeHandle: handle,
+			OriginalName:  originalName,
+			EnglishName:   englishName,
+		},
+		VTuberMeta: vtubers.VTuberMeta{ID: id},
+	}
+}
+
+func ids(vs []vtubers.VTuber) []int {
+	result := make([]int, len(vs))
+	for i, v := range vs {
+		result[i] = v.ID
+	}
+	return result
+}
+
+func TestDetect(t *testing.T) {
+	detector := vtubers.NewDetector([]vtubers.VTuber{
+		testVTuber(1, "UC0000000000000000000001", "@PekoraCh", "兎田ぺこら", "Usada Pekora"),
+		testVTuber(2, "UC0000000000000000000002", "@HoushouMarine", "宝鐘マリン", "Houshou Marine"),
+		testVTuber(3, "UC0000000000000000000003", "@ShirakamiFubuki", "白上フブキ", "Shirakami Fubuki"),
+		testVTuber(4, "", "", "叶", "Kanae"),
+	}, nil, nil)
+
+	result := detector.Detect(logs.VideoInfo{
+		Title:          "【コラボ】宝鐘マリン and Shirakami Fubuki play with 叶",
+		ChannelID:      "UC0000000000000000000001",
+		LinkedChannels: []string{"@houshoumarine", "UC0000000000000000000001", "@unknown"},
+	})
+
+	if got := ids(result.PrimaryChannel); !slices.Equal(got, []int{1}) {
+		t.Errorf("Expected primary channel [1] got %v", got)
+	}
+	if got := ids(result.LinkedChannel); !slices.Equal(got, []int{2}) {
+		t.Errorf("Expected linked channels [2] got %v", got)
+	}
+	if got := ids(result.NameText); !slices.Equal(got, []int{3}) {
+		t.Errorf("Expected name text [3] got %v", got)
+	}
+	if got := ids(result.All); !slices.Equal(got, []int{1, 2, 3}) {
+		t.Errorf("Expected all [1 2 3] got %v", got)
+	}
+}
+
+func TestDetectNoMatch(t *testing.T) {
+	detector := vtubers.NewDetector([]vtubers.VTuber{
+		testVTuber(1, "UC0000000000000000000001", "@PekoraCh", "兎田ぺこら", "Usada Pekora"),
+	}, nil, nil)
+
+	result := detector.Detect(logs.VideoInfo{
+		Title:     "Unrelated video",
+		ChannelID: "UC0000000000000000000009",
+	})
+
+	if len(result.All) != 0 {
+		t.Errorf("Expected no detections got %v", ids(result.All))
+	}
+}
+
+func TestDetectSharedChannel(t *testing.T) {
+	detector := vtubers.NewDetector([]vtubers.VTuber{
+		testVTuber(1, "UC0000000000000000000001", "@UnitCh", "ユニットA", "Unit Member A"),
+		testVTuber(2, "UC0000000000000000000001", "@UnitCh", "ユニットB", "Unit Member B"),
+		testVTuber(3, "UC0000000000000000000003", "@Other", "他のメンバー", "Other Member"),
+	}, nil, nil)
+
+	result := detector.Detect(logs.VideoInfo{
+		Title:          "Unit stream",
+		ChannelID:      "UC0000000000000000000001",
+		LinkedChannels: []string{"UC0000000000000000000003"},
+	})
+
+	if got := ids(result.PrimaryChannel); !slices.Equal(got, []int{1, 2}) {
+		t.Errorf("Expected primary channel [1 2] got %v", got)
+	}
+	if !result.SharedChannel() {
+		t.Errorf("Expected shared channel")
+	}
+	if got := ids(result.LinkedChannel); !slices.Equal(got, []int{3}) {
+		t.Errorf("Expected linked channels [3] got %v", got)
+	}
+}
+
+func TestDetectAttributed(t *testing.T) {
+	detector := vtubers.NewDetector([]vtubers.VTuber{
+		testVTuber(1, "UC0000000000000000000001", "@GawrGura", "", "Gawr Gura"),
+		testVTuber(2, "UC0000000000000000000002", "@MoriCalliope", "", "Mori Calliope"),
+		testVTuber(3, "UC0000000000000000000003", "@NinomaeInanis", "", "Ninomae Ina'nis"),
+	}, nil, nil)
+
+	result := detector.Detect(logs.VideoInfo{
+		Title:          "Karaoke with Mori Calliope",
+		ChannelID:      "UC0000000000000000000001",
+		LinkedChannels: []string{"@MoriCalliope", "@NinomaeInanis"},
+	})
+
+	expected := []vtubers.Detection{
+		{VTuber: testVTuber(1, "UC0000000000000000000001", "@GawrGura", "", "Gawr Gura"), Source: vtubers.SourcePrimaryChannel},
+		{VTuber: testVTuber(2, "UC0000000000000000000002", "@MoriCalliope", "", "Mori Calliope"), Source: vtubers.SourceNameText},
+	}
+	if got := result.Attributed(); !slices.EqualFunc(got, expected, func(a, b vtubers.Detection) bool {
+		return a.VTuber.ID == b.VTuber.ID && a.Source == b.Source
+	}) {
+		t.Errorf("Expected attributed %v got %v", expected, got)
+	}
+}
+
+func TestDetectHashtag(t *testing.T) {
+	pekora := testVTuber(1, "UC0000000000000000000001", "@PekoraCh", "兎田ぺこら", "Usada Pekora")
+	pekora.Hashtags = "#ぺこらいぶ #ぺこらーと"
+	marine := testVTuber(2, "UC0000000000000000000002", "@HoushouMarine", "宝鐘マリン", "Houshou Marine")
+	marine.Hashtags = "#マリン航海記 #hololive"
+	fubuki := testVTuber(3, "UC0000000000000000000003", "@ShirakamiFubuki", "白上フブキ", "Shirakami Fubuki")
+	fubuki.Hashtags = "#HOLOLIVE"
+	detector := vtubers.NewDetector([]vtubers.VTuber{pekora, marine, fubuki}, nil, nil)
+
+	result := detector.Detect(logs.VideoInfo{
+		Title:     "Clip ＃ぺこらいぶ #マリン航海記 #hololive",
+		ChannelID: "UC0000000000000000000009",
+	})
+
+	if got := ids(result.Hashtag); !slices.Equal(got, []int{1, 2}) {
+		t.Errorf("Expected hashtags [1 2] got %v", got)
+	}
+	if len(result.NameText) != 0 {
+		t.Errorf("Expected no name text got %v", ids(result.NameText))
+	}
+}
+
+func TestDetectTitleAttribution(t *testing.T) {
+	detector := vtubers.NewDetector([]vtubers.VTuber{
+		testVTuber(1, "UC0000000000000000000001", "@PekoraCh", "兎田ぺこら", "Usada Pekora"),
+		testVTuber(2, "UC0000000000000000000002", "@HoushouMarine", "宝鐘マリン", "Houshou Marine"),
+		testVTuber(3, "UC0000000000000000000003", "@ShirakamiFubuki", "白上フブキ", "Shirakami Fubuki"),
+		testVTuber(4, "", "", "叶", "Kanae"),
+		testVTuber(5, "", "", "葛葉", "Kuzuha"),
+	}, nil, nil)
+
+	tests := []struct {
+		title string
+		want  []int
+	}{
+		{"【Minecraft】Building a castle【兎田ぺこら / Houshou Marine】", []int{1, 2}},
+		{"Late night talk w/ @ShirakamiFubuki", []int{3}},
+		{"APEX ranked ft. 叶 & Kuzuha (Japanese)", []int{4, 5}},
+		{"Playing with 叶", nil},
+	}
+
+	for _, test := range tests {
+		result := detector.Detect(logs.VideoInfo{
+			Title:     test.title,
+			ChannelID: "UC0000000000000000000009",
+		})
+		if got := ids(result.TitleAttribution); !slices.Equal(got, test.want) && len(got)+len(test.want) > 0 {
+			t.Errorf("%q: expected title attributions %v got %v", test.title, test.want, got)
+		}
+	}
+}
+
+func TestDetectAlias(t *testing.T) {
+	detector := vtubers.NewDetector([]vtubers.VTuber{
+		testVTuber(1, "UC0000000000000000000001", "@PekoraCh", "兎田ぺこら", "Usada Pekora"),
+		testVTuber(2, "UC0000000000000000000002", "@HoushouMarine", "宝鐘マリン", "Houshou Marine"),
+	}, []vtubers.Alias{
+		{VTuberID: 1, Alias: "Pekora", Filter: vtubers.AliasFilterNone},
+		{VTuberID: 2, Alias: "Captain", Filter: vtubers.AliasFilterEnglish},
+		{VTuberID: 2, Alias: "Senchou Marine", Filter: vtubers.AliasFilterEnglish},
+		{VTuberID: 9, Alias: "Unknown", Filter: vtubers.AliasFilterNone},
+		{VTuberID: 2, Alias: "・", Filter: vtubers.AliasFilterNone},
+	}, nil)
+
+	result := detector.Detect(logs.VideoInfo{
+		Title:     "Pekora and the Captain Unknown collab",
+		ChannelID: "UC0000000000000000000009",
+	})
+
+	if got := ids(result.NameText); !slices.Equal(got, []int{1}) {
+		t.Errorf("Expected name text [1] got %v", got)
+	}
+
+	// Single word English aliases are only searched for without a filter.
+	result = detector.Detect(logs.VideoInfo{
+		Title:     "Senchou Marine returns",
+		ChannelID: "UC0000000000000000000009",
+	})
+
+	if got := ids(result.NameText); !slices.Equal(got, []int{2}) {
+		t.Errorf("Expected name text [2] got %v", got)
+	}
+
+	// Aliases that are empty once normalized are never searched for.
+	result = detector.Detect(logs.VideoInfo{
+		Title:     "hi Pekorb",
+		ChannelID: "UC0000000000000000000009",
+	})
+
+	if got := ids(result.NameText); len(got) != 0 {
+		t.Errorf("Expected no name text got %v", got)
+	}
+}
+
+func TestDetectNormalizedName(t *testing.T) {
+	detector := vtubers.NewDetector([]vtubers.VTuber{
+		testVTuber(1, "UC0000000000000000000001", "@PekoraCh", "兎田ぺこら", "Usada Pekora"),
+		testVTuber(2, "UC0000000000000000000002", "@Kiara", "タカナシ・キアラ", "Takanashi Kiara"),
+	}, nil, nil)
+
+	result := detector.Detect(logs.VideoInfo{
+		Title:     "ＵＳＡＤＡ ＰＥＫＯＲＡ and たかなしきあら",
+		ChannelID: "UC0000000000000000000009",
+	})
+
+	if got := ids(result.NameText); !slices.Equal(got, []int{1, 2}) {
+		t.Errorf("Expected name text [1 2] got %v", got)
+	}
+}
+
+func TestDetectSingleWordEnglishName(t *testing.T) {
+	detector := vtubers.NewDetector([]vtubers.VTuber{
+		testVTuber(1, "", "", "叶", "Kanae"),
+		testVTuber(2, "", "", "イナニス", "Ina"),
+	}, nil, nil)
+
+	result := detector.Detect(logs.VideoInfo{
+		Title:     "KANAE plays the Final level",
+		ChannelID: "UC0000000000000000000009",
+	})
+
+	if got := ids(result.NameText); !slices.Equal(got, []int{1}) {
+		t.Errorf("Expected name text [1] got %v", got)
+	}
+}
+
+func TestDetectNameMatches(t *testing.T) {
+	detector := vtubers.NewDetector([]vtubers.VTuber{
+		testVTuber(1, "", "", "アキ・ローゼンタール", "Aki Rosenthal"),
+		testVTuber(2, "", "", "ローゼンタール", "Rosenthal"),
+	}, nil, nil)
+
+	title := "Aki Rosenthal karaoke"
+	result := detector.Detect(logs.VideoInfo{Title: title})
+
+	if got := ids(result.NameText); !slices.Equal(got, []int{1}) {
+		t.Errorf("Expected name text [1] got %v", got)
+	}
+	if len(result.NameMatches) != 1 {
+		t.Fatalf("Expected one name match got %d", len(result.NameMatches))
+	}
+	match := result.NameMatches[0]
+	if got := title[match.Start:match.End]; got != "Aki Rosenthal" {
+		t.Errorf("Expected match of Aki Rosenthal got %q", got)
+	}
+}
+
+func TestDetectFuzzyName(t *testing.T) {
+	detector := vtubers.NewDetector([]vtubers.VTuber{
+		testVTuber(1, "", "", "兎田ぺこら", "Usada Pekora"),
+		testVTuber(2, "", "", "", "Gawr Gura"),
+		testVTuber(3, "", "", "", "Mumei"),
+	}, nil, nil)
+
+	title := "Usada Pecora and Gawr Gura collab with Mumai"
+	result := detector.Detect(logs.VideoInfo{Title: title})
+
+	if got := ids(result.NameText); !slices.Equal(got, []int{2}) {
+		t.Errorf("Expected name text [2] got %v", got)
+	}
+	// Mumei is too short to be matched approximately.
+	if got := ids(result.FuzzyName); !slices.Equal(got, []int{1}) {
+		t.Errorf("Expected fuzzy name [1] got %v", got)
+	}
+	i := slices.IndexFunc(result.NameMatches, func(m vtubers.NameMatch) bool {
+		return m.VTuber.ID == 1
+	})
+	if i < 0 {
+		t.Fatal("Expected a name match of 1")
+	}
+	match := result.NameMatches[i]
+	if got := title[match.Start:match.End]; got != "Usada Pecora" || match.Distance != 1 {
+		t.Errorf("Expected match of Usada Pecora at distance 1 got %q at %d", got, match.Distance)
+	}
+}
+
+func TestDetectRules(t *testing.T) {
+	detector := vtubers.NewDetector([]vtubers.VTuber{
+		testVTuber(1, "", "", "天音かなた", "Amane Kanata"),
+		testVTuber(2, "", "", "", "Gawr Gura"),
+	}, []vtubers.Alias{
+		{VTuberID: 1, Alias: "Kanata", Filter: vtubers.AliasFilterNone},
+	}, []vtubers.Rule{
+		{VTuberID: 1, Name: "kanata", Kind: vtubers.RuleExclude, Context: vtubers.RuleContextAfter, Pattern: `^\s*(?i)bridge`},
+		{VTuberID: 2, Kind: vtubers.RuleRequire, Context: vtubers.RuleContextTitle, Pattern: `(?i)hololive|ホロライブ`},
+	})
+
+	tests := []struct {
+		title    string
+		expected []int
+	}{
+		{"Kanata Bridge walk with Gawr Gura", nil},
+		{"KANATA bridge and Amane Kanata", []int{1}},
+		{"Kanata and Gawr Gura #hololive", []int{1, 2}},
+	}
+	for _, test := range tests {
+		result := detector.Detect(logs.VideoInfo{Title: test.title})
+		if got := ids(result.NameText); !slices.Equal(got, test.expected) {
+			t.Errorf("Expected name text %v for %q got %v", test.expected, test.title, got)
+		}
+	}
+}
+
+func TestCreateDetectorInvalidRule(t *testing.T) {
+	ctx := context.Background()
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	store, err := vtubers.CreateStore(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Added directly, as the store refuses invalid rules.
+	_, err = db.Exec(`
+		INSERT INTO detection_rules (vtuber_id, name, kind, context, pattern)
+		VALUES (1, '', 'exclude', 'title', '(?<=lookbehind)')
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = vtubers.CreateDetector(ctx, store)
+	if err == nil || !strings.Contains(err.Error(), "rule 1 of vtuber 1") {
+		t.Errorf("Expected an error naming the invalid rule got %v", err)
+	}
+}
+
+func TestExplain(t *testing.T) {
+	detector := vtubers.NewDetector([]vtubers.VTuber{
+		testVTuber(1, "UC0000000000000000000001", "@PekoraCh", "兎田ぺこら", "Usada Pekora"),
+		testVTuber(2, "", "", "天音かなた", "Amane Kanata"),
+		testVTuber(3, "UC0000000000000000000003", "@HoushouMarine", "宝鐘マリン", "Houshou Marine"),
+	}, []vtubers.Alias{
+		{VTuberID: 2, Alias: "Kanata", Filter: vtubers.AliasFilterNone},
+	}, []vtubers.Rule{
+		{ID: 7, VTuberID: 2, Kind: vtubers.RuleExclude, Context: vtubers.RuleContextAfter, Pattern: `^\s*bridge`},
+	})
+
+	e := detector.Explain(logs.VideoInfo{
+		Title:     "Usada Pekora visits Kanata bridge",
+		ChannelID: "UC0000000000000000000001",
+	})
+
+	expected := []vtubers.Evidence{
+		{Source: vtubers.SourcePrimaryChannel, Text: "UC0000000000000000000001"},
+		{Source: vtubers.SourceNameText, Text: "Usada Pekora"},
+	}
+	if len(e.Evidence) != len(expected) {
+		t.Fatalf("Expected %d pieces of evidence got %d", len(expected), len(e.Evidence))
+	}
+	for i, ev := range e.Evidence {
+		if ev.VTuber.ID != 1 || ev.Source != expected[i].Source || ev.Text != expected[i].Text {
+			t.Errorf("Expected evidence %s %q for 1 got %s %q for %d", expected[i].Source, expected[i].Text, ev.Source, ev.Text, ev.VTuber.ID)
+		}
+	}
+	if len(e.Rejected) != 1 || e.Rejected[0].VTuber.ID != 2 || e.Rejected[0].Rule.ID != 7 {
+		t.Errorf("Expected Kanata to be rejected by rule 7 got %+v", e.Rejected)
+	}
+
+	var b strings.Builder
+	if err := e.WriteText(&b); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), "rule 7") {
+		t.Errorf("Expected the report to name rule 7 got:\n%s", b.String())
+	}
+
+	// Linked channels left out of the attribution are reported as rejected.
+	e = detector.Explain(logs.VideoInfo{
+		Title:          "Usada Pekora plays Minecraft",
+		ChannelID:      "UC0000000000000000000001",
+		LinkedChannels: []string{"UC0000000000000000000003"},
+	})
+	b.Reset()
+	if err := e.WriteText(&b); err != nil {
+		t.Fatal(err)
+	}
+	detected, rejected, ok := strings.Cut(b.String(), "Rejected as not attributed:")
+	rejected, _, _ = strings.Cut(rejected, "Evidence:")
+	if !ok || strings.Contains(detected, "Houshou Marine") || !strings.Contains(rejected, "Houshou Marine (3)") {
+		t.Errorf("Expected Houshou Marine to be rejected as not attributed got:\n%s", b.String())
+	}
+}
@@ -0,0 +1,166 @@
+package vtubers
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"text/tabwriter"
+
+	"github.com/xoltia/botsu-oshi-stats/logs"
+)
+
+// LabeledVideo is a video along with the vtubers known to appear in it.
+type LabeledVideo struct {
+	Video logs.VideoInfo `json:"video"`
+	// IDs of every vtuber appearing in the video.
+	Expected []int `json:"expected"`
+	// Why the video is labeled the way it is, for cases that are easy to get wrong.
+	Note string `json:"note,omitempty"`
+}
+
+// Roster is a fixed set of vtubers and dictionary entries, used to
+// evaluate detection independently of the contents of the store.
+type Roster struct {
+	VTubers []VTuber `json:"vtubers"`
+	Aliases []Alias  `json:"aliases"`
+	Rules   []Rule   `json:"rules"`
+}
+
+// SourceScore counts the detections made by a single source.
+type SourceScore struct {
+	Source         Source
+	TruePositives  int
+	FalsePositives int
+}
+
+func (s SourceScore) Precision() float64 {
+	return ratio(s.TruePositives, s.TruePositives+s.FalsePositives)
+}
+
+// Mistake is a labeled video that the detector got wrong.
+type Mistake struct {
+	Video  logs.VideoInfo
+	Missed []VTuber
+	Extra  []Detection
+}
+
+// Evaluation is the accuracy of a detector on a set of labeled videos.
+type Evaluation struct {
+	Videos         int
+	Expected       int
+	TruePositives  int
+	FalsePositives int
+	// Scores of every source that detected anything, in Source order.
+	Sources  []SourceScore
+	Mistakes []Mistake
+}
+
+// Precision is the fraction of detections that were expected.
+func (e Evaluation) Precision() float64 {
+	return ratio(e.TruePositives, e.TruePositives+e.FalsePositives)
+}
+
+// Recall is the fraction of expected vtubers that were detected.
+func (e Evaluation) Recall() float64 {
+	return ratio(e.TruePositives, e.Expected)
+}
+
+// Returns one when there is nothing to divide, as nothing was wrong.
+func ratio(n, d int) float64 {
+	if d == 0 {
+		return 1
+	}
+	return float64(n) / float64(d)
+}
+
+// Evaluate runs the detector on every labeled video, comparing the
+// attributed vtubers to the expected ones.
+func Evaluate(d *Detector, videos []LabeledVideo) Evaluation {
+	t := d.tables.Load()
+	e := Evaluation{Videos: len(videos)}
+	scores := make(map[Source]*SourceScore)
+
+	for _, labeled := range videos {
+		var (
+			expected = slices.Compact(slices.Sorted(slices.Values(labeled.Expected)))
+			mistake  = Mistake{Video: labeled.Video}
+			found    = make(map[int]bool)
+		)
+		e.Expected += len(expected)
+
+		for _, detection := range d.Detect(labeled.Video).Attributed() {
+			score, ok := scores[detection.Source]
+			if !ok {
+				score = &SourceScore{Source: detection.Source}
+				scores[detection.Source] = score
+			}
+			if _, ok := slices.BinarySearch(expected, detection.VTuber.ID); ok {
+				score.TruePositives++
+				e.TruePositives++
+				found[detection.VTuber.ID] = true
+			} else {
+				score.FalsePositives++
+				e.FalsePositives++
+				mistake.Extra = append(mistake.Extra, detection)
+			}
+		}
+
+		for _, id := range expected {
+			if found[id] {
+				continue
+			}
+			vtuber, ok := t.byID[id]
+			if !ok {
+				vtuber.ID = id
+			}
+			mistake.Missed = append(mistake.Missed, vtuber)
+		}
+		if len(mistake.Missed) > 0 || len(mistake.Extra) > 0 {
+			e.Mistakes = append(e.Mistakes, mistake)
+		}
+	}
+
+	for _, score := range scores {
+		e.Sources = append(e.Sources, *score)
+	}
+	slices.SortFunc(e.Sources, func(a, b SourceScore) int {
+		return int(a.Source) - int(b.Source)
+	})
+	return e
+}
+
+// WriteText writes the evaluation as a human readable report.
+func (e Evaluation) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "Videos:\t%d\n", e.Videos)
+	fmt.Fprintf(tw, "Precision:\t%.3f\t(%d/%d)\n", e.Precision(), e.TruePositives, e.TruePositives+e.FalsePositives)
+	fmt.Fprintf(tw, "Recall:\t%.3f\t(%d/%d)\n", e.Recall(), e.TruePositives, e.Expected)
+
+	// Recall of a source is the fraction of expected vtubers it detected,
+	// as a vtuber that was missed can't be attributed to any source.
+	fmt.Fprintln(tw, "\nSource\tDetected\tCorrect\tPrecision\tRecall")
+	for _, s := range e.Sources {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.3f\t%.3f\n",
+			s.Source,
+			s.TruePositives+s.FalsePositives,
+			s.TruePositives,
+			s.Precision(),
+			ratio(s.TruePositives, e.Expected))
+	}
+
+	if len(e.Mistakes) > 0 {
+		fmt.Fprintln(tw, "\nMistakes:")
+	}
+	for _, m := range e.Mistakes {
+		fmt.Fprintf(tw, "  %s\t%q\n", m.Video.ID, m.Video.Title)
+		for _, v := range m.Missed {
+			fmt.Fprintf(tw, "  \tmissed %s (%d)\n", v.EnglishName, v.ID)
+		}
+		for _, d := range m.Extra {
+			fmt.Fprintf(tw, "  \textra %s (%d) from %s\n", d.VTuber.EnglishName, d.VTuber.ID, d.Source)
+		}
+	}
+
+	return tw.Flush()
+}
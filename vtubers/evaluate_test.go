@@ -0,0 +1,60 @@
+package vtubers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/xoltia/botsu-oshi-stats/vtubers"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden evaluation report")
+
+func readJSON(t *testing.T, name string, v any) {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "evaluation", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		t.Fatalf("%s: %s", name, err)
+	}
+}
+
+// Compares the evaluation on the labeled corpus to the accepted report.
+// After an intended change in detection, review the difference and run
+// with -update to accept it.
+func TestEvaluateGolden(t *testing.T) {
+	var (
+		roster vtubers.Roster
+		videos []vtubers.LabeledVideo
+	)
+	readJSON(t, "roster.json", &roster)
+	readJSON(t, "videos.json", &videos)
+
+	detector := vtubers.NewDetector(roster.VTubers, roster.Aliases, roster.Rules)
+	evaluation := vtubers.Evaluate(detector, videos)
+
+	var report bytes.Buffer
+	if err := evaluation.WriteText(&report); err != nil {
+		t.Fatal(err)
+	}
+
+	golden := filepath.Join("testdata", "evaluation", "report.golden")
+	if *update {
+		if err := os.WriteFile(golden, report.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(report.Bytes(), want) {
+		t.Errorf("Evaluation differs from %s, got:\n%s", golden, report.Bytes())
+	}
+}
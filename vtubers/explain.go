@@ -0,0 +1,115 @@
+package vtubers
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"text/tabwriter"
+
+	"github.com/xoltia/botsu-oshi-stats/logs"
+)
+
+// Evidence is a single hint of a vtuber found in video metadata. A vtuber
+// may have several, in which case only the first decides its source.
+type Evidence struct {
+	VTuber VTuber
+	Source Source
+	// Channel ID, handle, name or hashtag as it appears in the video.
+	Text string
+	// Number of characters differing from the name for approximate name matches.
+	Distance int
+}
+
+// RejectedMatch is a name found in the title which a rule prevented
+// from being attributed.
+type RejectedMatch struct {
+	NameMatch
+	Rule Rule
+}
+
+// Explanation describes how the vtubers of a video were detected.
+type Explanation struct {
+	Video  logs.VideoInfo
+	Result DetectionResult
+	// Every hint found, in the order they were searched for.
+	Evidence []Evidence
+	Rejected []RejectedMatch
+}
+
+// Explain detects vtubers in the video like Detect, also keeping
+// the evidence found for each of them.
+func (d *Detector) Explain(video logs.VideoInfo) Explanation {
+	e := Explanation{Video: video}
+	e.Result = d.detect(video, &e)
+	return e
+}
+
+func (e *Explanation) add(vtuber VTuber, source Source, text string, distance int) {
+	if e != nil {
+		e.Evidence = append(e.Evidence, Evidence{vtuber, source, text, distance})
+	}
+}
+
+func (e *Explanation) reject(match NameMatch, rule Rule) {
+	if e != nil {
+		e.Rejected = append(e.Rejected, RejectedMatch{match, rule})
+	}
+}
+
+// WriteText writes the explanation as a human readable report.
+func (e Explanation) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "Video:\t%s\n", e.Video.ID)
+	fmt.Fprintf(tw, "Title:\t%s\n", e.Video.Title)
+	fmt.Fprintf(tw, "Channel:\t%s %s\n", e.Video.ChannelID, e.Video.ChannelHandle)
+	for _, link := range e.Video.LinkedChannels {
+		fmt.Fprintf(tw, "Linked:\t%s\n", link)
+	}
+
+	attributed := e.Result.Attributed()
+	fmt.Fprintln(tw, "\nDetected:")
+	if len(attributed) == 0 {
+		fmt.Fprintln(tw, "  none")
+	}
+	for _, d := range attributed {
+		fmt.Fprintf(tw, "  %s (%d)\t%s\t%.2f\n", d.VTuber.EnglishName, d.VTuber.ID, d.Source, d.Source.Confidence())
+	}
+
+	dropped := e.Result.Detections()
+	dropped = slices.DeleteFunc(dropped, func(d Detection) bool {
+		return slices.ContainsFunc(attributed, func(a Detection) bool {
+			return a.VTuber.ID == d.VTuber.ID
+		})
+	})
+	if len(dropped) > 0 {
+		fmt.Fprintln(tw, "\nRejected as not attributed:")
+	}
+	for _, d := range dropped {
+		fmt.Fprintf(tw, "  %s (%d)\t%s\n", d.VTuber.EnglishName, d.VTuber.ID, d.Source)
+	}
+
+	fmt.Fprintln(tw, "\nEvidence:")
+	if len(e.Evidence) == 0 {
+		fmt.Fprintln(tw, "  none")
+	}
+	for _, ev := range e.Evidence {
+		text := fmt.Sprintf("%q", ev.Text)
+		if ev.Distance > 0 {
+			text += fmt.Sprintf(" (distance %d)", ev.Distance)
+		}
+		fmt.Fprintf(tw, "  %s (%d)\t%s\t%s\n", ev.VTuber.EnglishName, ev.VTuber.ID, ev.Source, text)
+	}
+
+	if len(e.Rejected) > 0 {
+		fmt.Fprintln(tw, "\nRejected by rules:")
+	}
+	for _, r := range e.Rejected {
+		fmt.Fprintf(tw, "  %s (%d)\t%q\trule %d: %s %s %s\n",
+			r.VTuber.EnglishName, r.VTuber.ID,
+			e.Video.Title[r.Start:r.End],
+			r.Rule.ID, r.Rule.Kind, r.Rule.Context, r.Rule.Pattern)
+	}
+
+	return tw.Flush()
+}
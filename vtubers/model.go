@@ -20,6 +20,10 @@ type VTuberRendered struct {
 	Height        string `db:"height"`
 	Fanbase       string `db:"fanbase"`
 	Status        string `db:"status"`
+	// Official hashtags separated by spaces, including the leading '#'.
+	Hashtags string `db:"hashtags"`
+	// Nicknames listed on the page, stored separately as scraped aliases.
+	Nicknames []string `db:"-"`
 }
 
 type VTuberMeta struct {
@@ -35,3 +39,68 @@ type Channel struct {
 	AvatarURL string `db:"avatar_url"`
 	BannerURL string `db:"banner_url"`
 }
+
+// AliasSource is where an alias came from. Scraped aliases are replaced on
+// every update while admin aliases are only changed by hand.
+type AliasSource string
+
+const (
+	AliasSourceAdmin   AliasSource = "admin"
+	AliasSourceScraped AliasSource = "scraped"
+)
+
+// AliasFilter selects the check used to reject aliases that are too
+// likely to cause false positives when searching text.
+type AliasFilter string
+
+const (
+	// Filtered like original names, rejecting short kana only names.
+	AliasFilterOriginal AliasFilter = "original"
+	// Rejects single words, which unlike English names are often common words.
+	AliasFilterEnglish AliasFilter = "english"
+	// Always searched for.
+	AliasFilterNone AliasFilter = "none"
+)
+
+// Alias is an additional name that a vtuber is known by, such as a
+// nickname, first name or kana variant.
+type Alias struct {
+	VTuberID int         `db:"vtuber_id"`
+	Alias    string      `db:"alias"`
+	Source   AliasSource `db:"source"`
+	Filter   AliasFilter `db:"filter"`
+}
+
+// RuleKind decides whether the pattern of a rule must or must
+// not be found for a name in a title to count.
+type RuleKind string
+
+const (
+	RuleRequire RuleKind = "require"
+	RuleExclude RuleKind = "exclude"
+)
+
+// RuleContext is the part of the title that the pattern of a rule is matched against.
+type RuleContext string
+
+const (
+	// Text before the name.
+	RuleContextBefore RuleContext = "before"
+	// Text after the name.
+	RuleContextAfter RuleContext = "after"
+	// The whole title.
+	RuleContextTitle RuleContext = "title"
+)
+
+// Rule restricts when a name found in a title is attributed to a vtuber, such
+// as excluding a name when it is followed by a word it's often used with.
+type Rule struct {
+	ID       int64 `db:"id"`
+	VTuberID int   `db:"vtuber_id"`
+	// Name or alias the rule applies to, or empty for every name of the vtuber.
+	Name    string      `db:"name"`
+	Kind    RuleKind    `db:"kind"`
+	Context RuleContext `db:"context"`
+	// Regular expression in RE2 syntax matched against the title as is.
+	Pattern string `db:"pattern"`
+}
@@ -0,0 +1,85 @@
+package vtubers
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// Rule ready to be evaluated against name matches.
+type compiledRule struct {
+	Rule
+	// Normalized name, or empty for every name.
+	name    string
+	pattern *regexp.Regexp
+}
+
+func compileRule(r Rule) (compiledRule, error) {
+	switch r.Kind {
+	case RuleRequire, RuleExclude:
+	default:
+		return compiledRule{}, fmt.Errorf("unknown rule kind %q", r.Kind)
+	}
+	switch r.Context {
+	case RuleContextBefore, RuleContextAfter, RuleContextTitle:
+	default:
+		return compiledRule{}, fmt.Errorf("unknown rule context %q", r.Context)
+	}
+	pattern, err := regexp.Compile(r.Pattern)
+	if err != nil {
+		return compiledRule{}, fmt.Errorf("rule pattern: %w", err)
+	}
+	return compiledRule{
+		Rule:    r,
+		name:    nameNormalization.ApplyString(r.Name),
+		pattern: pattern,
+	}, nil
+}
+
+// Validate checks that the kind and context of the rule
+// are known and that its pattern compiles.
+func (r Rule) Validate() error {
+	_, err := compileRule(r)
+	return err
+}
+
+// Returns an error naming every rule that fails validation. Rules are
+// validated when added, but stored rules may stop compiling when the
+// supported pattern syntax changes.
+func validateRules(rules []Rule) error {
+	var errs []error
+	for _, r := range rules {
+		if err := r.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("rule %d of vtuber %d: %w", r.ID, r.VTuberID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Returns the first rule that a name found at title[start:end] doesn't
+// satisfy, if any. Rules for a specific name don't apply to approximate
+// matches of it, as the matched text differs from the name.
+func rejectingRule(rules []compiledRule, title string, start, end int) (Rule, bool) {
+	if len(rules) == 0 {
+		return Rule{}, false
+	}
+	name := nameNormalization.ApplyString(title[start:end])
+	for _, r := range rules {
+		if r.name != "" && r.name != name {
+			continue
+		}
+		var context string
+		switch r.Context {
+		case RuleContextBefore:
+			context = title[:start]
+		case RuleContextAfter:
+			context = title[end:]
+		default:
+			context = title
+		}
+		if r.pattern.MatchString(context) != (r.Kind == RuleRequire) {
+			return r.Rule, true
+		}
+	}
+	return Rule{}, false
+}
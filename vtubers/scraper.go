@@ -2,11 +2,14 @@ package vtubers
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -48,18 +51,30 @@ func (s *HololistScraper) Reset() {
 	s.offset = 0
 }
 
+// Offset is the number of posts paginated through.
+func (s *HololistScraper) Offset() int {
+	return s.offset
+}
+
+// Seek continues post pagination from the given offset.
+func (s *HololistScraper) Seek(offset int) {
+	s.offset = offset
+}
+
 func (s *HololistScraper) getWithBackoff(
 	ctx context.Context,
 	url string,
+	header http.Header,
 	initial time.Duration,
 	maxAttempts int,
 ) (*http.Response, error) {
-	return s.getWithBackoffAttempt(ctx, url, initial, 0, maxAttempts)
+	return s.getWithBackoffAttempt(ctx, url, header, initial, 0, maxAttempts)
 }
 
 func (s *HololistScraper) getWithBackoffAttempt(
 	ctx context.Context,
 	url string,
+	header http.Header,
 	initial time.Duration,
 	attempt, maxAttempts int,
 ) (*http.Response, error) {
@@ -67,6 +82,9 @@ func (s *HololistScraper) getWithBackoffAttempt(
 	if err != nil {
 		return nil, err
 	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
 	err = s.limiter.Wait(req.Context())
 	if err != nil {
 		return nil, err
@@ -92,59 +110,250 @@ func (s *HololistScraper) getWithBackoffAttempt(
 		}
 	}
 
-	return s.getWithBackoffAttempt(ctx, url, initial, attempt+1, maxAttempts)
+	return s.getWithBackoffAttempt(ctx, url, header, initial, attempt+1, maxAttempts)
+}
+
+// PostsPage is a page of posts along with the validators to request it again with.
+type PostsPage struct {
+	Offset       int
+	Limit        int
+	Posts        []VTuberMeta
+	ETag         string
+	LastModified string
 }
 
 // Get the next page of posts. Returns ErrExhaustedPosts when there are no more to fetch.
 // Not safe for concurrent access.
 func (s *HololistScraper) NextPosts(ctx context.Context, limit int, maxAttempts int) ([]VTuberMeta, error) {
+	page, _, err := s.NextPostsPage(ctx, limit, maxAttempts, nil)
+	return page.Posts, err
+}
+
+// NextPostsPage gets the next page of posts like NextPosts. When given the page
+// previously fetched at the same offset, it is only downloaded again if it has
+// changed since, otherwise being returned as is and reported as not modified.
+func (s *HololistScraper) NextPostsPage(
+	ctx context.Context,
+	limit int,
+	maxAttempts int,
+	cached *PostsPage,
+) (page PostsPage, notModified bool, err error) {
+	if cached != nil && (cached.Offset != s.offset || cached.Limit != limit) {
+		cached = nil
+	}
+
+	header := make(http.Header)
+	if cached != nil && cached.ETag != "" {
+		header.Set("If-None-Match", cached.ETag)
+	}
+	if cached != nil && cached.LastModified != "" {
+		header.Set("If-Modified-Since", cached.LastModified)
+	}
+
 	url := fmt.Sprintf("%s?type=216&per_page=%d&offset=%d", postsEndpoint, limit, s.offset)
-	res, err := s.getWithBackoff(ctx, url, time.Second, maxAttempts)
+	res, err := s.getWithBackoff(ctx, url, header, time.Second, maxAttempts)
 	if err != nil {
-		return nil, err
+		return
 	}
+	defer res.Body.Close()
 	decoder := json.NewDecoder(res.Body)
 
+	if cached != nil && res.StatusCode == http.StatusNotModified {
+		s.offset += len(cached.Posts)
+		return *cached, true, nil
+	}
 	if res.Header.Get("X-WP-Total") == "0" {
-		return nil, ErrExhaustedPosts
+		err = ErrExhaustedPosts
+		return
 	}
 	if res.StatusCode == http.StatusForbidden {
-		return nil, ErrBackoff
+		err = ErrBackoff
+		return
 	}
 
 	if res.StatusCode != http.StatusOK {
 		var apiError UnknownError
-		err := decoder.Decode(&apiError)
+		if err = decoder.Decode(&apiError); err != nil {
+			err = fmt.Errorf("decode api error: %w", err)
+		} else if apiError.Code == "rest_post_invalid_page_number" {
+			err = ErrExhaustedPosts
+		} else {
+			err = apiError
+		}
+		return
+	}
+
+	page = PostsPage{
+		Offset:       s.offset,
+		Limit:        limit,
+		Posts:        make([]VTuberMeta, 0, limit),
+		ETag:         res.Header.Get("ETag"),
+		LastModified: res.Header.Get("Last-Modified"),
+	}
+	if err = decoder.Decode(&page.Posts); err != nil {
+		err = fmt.Errorf("decode posts: %w", err)
+		return
+	}
+	s.offset += len(page.Posts)
+	return
+}
+
+// HololistSourceName is the name records scraped from hololist are stored under.
+const HololistSourceName = "hololist"
+
+// HololistSource is the talent source of vtubers listed on hololist.net,
+// using their post IDs as vtuber IDs.
+type HololistSource struct {
+	Scraper            *HololistScraper
+	BatchSize          int
+	MaxRequestAttempts int
+	// Keeps the progress of an update along with the pages of posts when set,
+	// so that an interrupted update resumes where it stopped and unchanged
+	// pages aren't downloaded again.
+	Store *Store
+}
+
+func (s *HololistSource) applyDefaults() {
+	if s.BatchSize == 0 {
+		s.BatchSize = 100
+	}
+	if s.MaxRequestAttempts == 0 {
+		s.MaxRequestAttempts = 5
+	}
+}
+
+func (s *HololistSource) Name() string {
+	return HololistSourceName
+}
+
+// Records scrapes every post, only fetching the pages of those modified
+// since they were stored.
+func (s *HololistSource) Records(ctx context.Context, stored map[string]SourceRecord) ([]SourceRecord, error) {
+	s.applyDefaults()
+	s.Scraper.Reset()
+
+	var (
+		// Pages before it were handled by an interrupted update.
+		resumeOffset int
+		pending      = make(map[string]SourceRecord)
+	)
+	if s.Store != nil {
+		var err error
+		resumeOffset, err = s.Store.GetSourceProgress(ctx, s.Name())
+		if err != nil {
+			return nil, fmt.Errorf("get progress: %w", err)
+		}
+		records, err := s.Store.GetPendingRecords(ctx, s.Name())
 		if err != nil {
-			return nil, fmt.Errorf("decode api error: %w", err)
+			return nil, fmt.Errorf("get pending records: %w", err)
 		}
+		for _, r := range records {
+			pending[r.Key] = r
+		}
+	}
 
-		if apiError.Code == "rest_post_invalid_page_number" {
-			return nil, ErrExhaustedPosts
-		} else {
-			return nil, apiError
+	var records []SourceRecord
+	for {
+		posts, err := s.nextPosts(ctx, resumeOffset)
+		if err != nil {
+			if errors.Is(err, ErrExhaustedPosts) {
+				break
+			}
+			return nil, err
+		}
+
+		for _, meta := range posts {
+			key := strconv.Itoa(meta.ID)
+			if r, ok := pending[key]; ok && r.Modified == meta.Modified {
+				records = append(records, r)
+				continue
+			}
+			if r, ok := stored[key]; ok && r.Modified == meta.Modified {
+				records = append(records, r)
+				continue
+			}
+
+			rendered, err := s.Scraper.GetRenderedPost(ctx, meta.Link, s.MaxRequestAttempts)
+			if err != nil {
+				return nil, err
+			}
+			r := SourceRecord{
+				Key:            key,
+				ID:             meta.ID,
+				Link:           meta.Link,
+				Modified:       meta.Modified,
+				VTuberRendered: rendered,
+			}
+			records = append(records, r)
+
+			if s.Store != nil {
+				if err := s.Store.AddPendingRecord(ctx, s.Name(), r); err != nil {
+					return nil, fmt.Errorf("add pending record: %w", err)
+				}
+			}
 		}
+
+		if s.Store != nil && s.Scraper.Offset() > resumeOffset {
+			if err := s.Store.SetSourceProgress(ctx, s.Name(), s.Scraper.Offset()); err != nil {
+				return nil, fmt.Errorf("set progress: %w", err)
+			}
+		}
+	}
+
+	return records, nil
+}
+
+// Gets the next page of posts, reusing the stored page without a request
+// when it was handled before resumeOffset.
+func (s *HololistSource) nextPosts(ctx context.Context, resumeOffset int) ([]VTuberMeta, error) {
+	if s.Store == nil {
+		return s.Scraper.NextPosts(ctx, s.BatchSize, s.MaxRequestAttempts)
+	}
+
+	var cached *PostsPage
+	page, err := s.Store.GetPostsPage(ctx, s.Scraper.Offset(), s.BatchSize)
+	if err == nil {
+		cached = &page
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("get page: %w", err)
+	}
+
+	if cached != nil && cached.Offset < resumeOffset && len(cached.Posts) > 0 {
+		s.Scraper.Seek(cached.Offset + len(cached.Posts))
+		return cached.Posts, nil
 	}
 
-	result := make([]VTuberMeta, 0, limit)
-	if err = decoder.Decode(&result); err != nil {
-		return nil, fmt.Errorf("decode posts: %w", err)
+	page, notModified, err := s.Scraper.NextPostsPage(ctx, s.BatchSize, s.MaxRequestAttempts, cached)
+	if err != nil {
+		return nil, err
+	}
+	// Without posts the offset stays the same, so the next page would be the same.
+	if len(page.Posts) == 0 {
+		return nil, ErrExhaustedPosts
+	}
+	if !notModified {
+		if err := s.Store.SavePostsPage(ctx, page); err != nil {
+			return nil, fmt.Errorf("save page: %w", err)
+		}
 	}
-	s.offset += len(result)
-	return result, nil
+	return page.Posts, nil
 }
 
 var (
-	handleRegex = regexp.MustCompile(`(?:youtube.com/)(@.+)`)
+	handleRegex  = regexp.MustCompile(`(?:youtube.com/)(@.+)`)
+	hashtagRegex = regexp.MustCompile(`[#＃][\p{L}\p{N}_]+`)
+	// Nicknames are listed on one line separated by commas or slashes.
+	nicknameSeparatorRegex = regexp.MustCompile(`[,，、/／]`)
 )
 
 // Get a rendered post from the webpage URL. Can be obtained from `VTuberMeta.URL`.
 // Safe to use concurrently.
 func (s *HololistScraper) GetRenderedPost(ctx context.Context, url string, maxAttempts int) (v VTuberRendered, err error) {
-	res, err := s.getWithBackoff(ctx, url, time.Second, maxAttempts)
+	res, err := s.getWithBackoff(ctx, url, nil, time.Second, maxAttempts)
 	if err != nil {
 		return
 	}
+	defer res.Body.Close()
 
 	if res.StatusCode != http.StatusOK {
 		err = fmt.Errorf("status not ok: %s", res.Status)
@@ -179,6 +388,27 @@ func (s *HololistScraper) GetRenderedPost(ctx context.Context, url string, maxAt
 	v.Status = doc.Find("#status").First().Text()
 	v.Status = lastLineStripped(v.Status)
 
+	nicknames := doc.Find("#nickname").First().Text()
+	nicknames = lastLineStripped(nicknames)
+	for _, nickname := range nicknameSeparatorRegex.Split(nicknames, -1) {
+		nickname = strings.TrimSpace(nickname)
+		if nickname != "" && !slices.Contains(v.Nicknames, nickname) {
+			v.Nicknames = append(v.Nicknames, nickname)
+		}
+	}
+
+	// Stream, fanart and fan name tags are listed in separate sections.
+	var hashtags []string
+	for _, section := range doc.Find("[id*=hashtag]").EachIter() {
+		for _, tag := range hashtagRegex.FindAllString(section.Text(), -1) {
+			tag = normalizeHashtag(tag)
+			if !slices.Contains(hashtags, tag) {
+				hashtags = append(hashtags, tag)
+			}
+		}
+	}
+	v.Hashtags = strings.Join(hashtags, " ")
+
 	img := doc.Find("#left").First().Find("a").First().AttrOr("href", "")
 	v.PictureURL = img
 
@@ -204,6 +434,14 @@ func (s *HololistScraper) GetRenderedPost(ctx context.Context, url string, maxAt
 	return
 }
 
+// Replaces a full-width hash sign so that tags compare equal.
+func normalizeHashtag(tag string) string {
+	if rest, ok := strings.CutPrefix(tag, "＃"); ok {
+		return "#" + rest
+	}
+	return tag
+}
+
 func filterEmpty(text string) string {
 	if text == "...." {
 		return ""
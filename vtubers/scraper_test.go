@@ -0,0 +1,114 @@
+package vtubers_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/xoltia/botsu-oshi-stats/vtubers"
+	"golang.org/x/time/rate"
+)
+
+// Serves three posts in pages of two, answering conditional requests.
+type fakeHololist struct {
+	pages, notModified, posts int
+	// Fails the request for the page at this offset when positive.
+	failOffset int
+}
+
+func (f *fakeHololist) RoundTrip(req *http.Request) (*http.Response, error) {
+	respond := func(status int, header http.Header, body string) (*http.Response, error) {
+		if header == nil {
+			header = make(http.Header)
+		}
+		return &http.Response{
+			StatusCode: status,
+			Header:     header,
+			Body:       io.NopCloser(strings.NewReader(body)),
+			Request:    req,
+		}, nil
+	}
+
+	if !strings.Contains(req.URL.Path, "/wp-json/") {
+		f.posts++
+		name := strings.Trim(req.URL.Path, "/")
+		return respond(http.StatusOK, nil, fmt.Sprintf("<html><h1>%s</h1></html>", name))
+	}
+
+	offset, _ := strconv.Atoi(req.URL.Query().Get("offset"))
+	if f.failOffset > 0 && offset == f.failOffset {
+		return nil, errors.New("connection reset")
+	}
+	if offset >= 3 {
+		return respond(http.StatusBadRequest, nil, `{"code": "rest_post_invalid_page_number", "message": ""}`)
+	}
+
+	etag := fmt.Sprintf(`"page-%d"`, offset)
+	if req.Header.Get("If-None-Match") == etag {
+		f.notModified++
+		return respond(http.StatusNotModified, nil, "")
+	}
+	f.pages++
+
+	var posts []string
+	for id := offset + 1; id <= min(offset+2, 3); id++ {
+		posts = append(posts, fmt.Sprintf(`{"id": %d, "link": "https://hololist.net/vtuber-%d/", "modified": "2024"}`, id, id))
+	}
+	header := http.Header{"Etag": {etag}}
+	return respond(http.StatusOK, header, "["+strings.Join(posts, ",")+"]")
+}
+
+func TestHololistSourceResumes(t *testing.T) {
+	ctx := context.Background()
+	store := createTestStore(t)
+
+	hololist := &fakeHololist{failOffset: 2}
+	scraper := vtubers.NewHololistScraper(&http.Client{Transport: hololist}, rate.NewLimiter(rate.Inf, 1))
+	updater := vtubers.Updater{
+		Store: store,
+		Sources: []vtubers.TalentSource{&vtubers.HololistSource{
+			Scraper:   scraper,
+			BatchSize: 2,
+			Store:     store,
+		}},
+	}
+
+	if err := updater.Update(ctx); err == nil {
+		t.Fatal("Expected the interrupted update to fail")
+	}
+	if hololist.pages != 1 || hololist.posts != 2 {
+		t.Errorf("Expected 1 page and 2 posts fetched got %d and %d", hololist.pages, hololist.posts)
+	}
+
+	// Resumes after the first page without requesting it or its posts again.
+	*hololist = fakeHololist{}
+	if err := updater.Update(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if hololist.pages != 1 || hololist.notModified != 0 || hololist.posts != 1 {
+		t.Errorf("Expected 1 page and 1 post fetched on resume got %d and %d, %d not modified",
+			hololist.pages, hololist.posts, hololist.notModified)
+	}
+	all, err := store.GetAll(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 3 {
+		t.Errorf("Expected 3 vtubers got %d", len(all))
+	}
+
+	// Starts over, with every page unchanged.
+	*hololist = fakeHololist{}
+	if err := updater.Update(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if hololist.pages != 0 || hololist.notModified != 2 || hololist.posts != 0 {
+		t.Errorf("Expected 2 pages not modified got %d, %d pages and %d posts fetched",
+			hololist.notModified, hololist.pages, hololist.posts)
+	}
+}
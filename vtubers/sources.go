@@ -0,0 +1,381 @@
+package vtubers
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// SourceRecord is a vtuber as described by a single talent source.
+type SourceRecord struct {
+	// Identifies the record within its source across updates.
+	Key string `db:"key"`
+	// ID of the vtuber when the source has its own, as hololist does.
+	// Records without one are merged into the vtuber using the same
+	// YouTube channel, or otherwise given a negative ID of their own.
+	ID       int    `db:"id"`
+	Link     string `db:"link"`
+	Modified string `db:"modified"`
+	VTuberRendered
+}
+
+// StoredRecord is a source record along with the vtuber it was merged into.
+type StoredRecord struct {
+	SourceRecord
+	VTuberID int `db:"vtuber_id"`
+}
+
+// TalentSource provides the records that the Updater merges into vtubers.
+type TalentSource interface {
+	// Name identifies the records of the source in the store, so it
+	// must stay the same between updates.
+	Name() string
+	// Records returns every record of the source. Stored holds the records
+	// returned by the previous update by key, which may be returned again
+	// in place of fetching records that haven't changed.
+	Records(ctx context.Context, stored map[string]SourceRecord) ([]SourceRecord, error)
+}
+
+type recordField struct {
+	name  string
+	value *string
+}
+
+// Fields of a record by column name, which are merged and imported the same way.
+func renderedFields(v *VTuberRendered) []recordField {
+	return []recordField{
+		{"youtube_id", &v.YouTubeID},
+		{"youtube_handle", &v.YouTubeHandle},
+		{"picture_url", &v.PictureURL},
+		{"original_name", &v.OriginalName},
+		{"english_name", &v.EnglishName},
+		{"oshi_mark", &v.OshiMark},
+		{"zodiac", &v.Zodiac},
+		{"affiliation", &v.Affiliation},
+		{"birthday", &v.Birthday},
+		{"debut_date", &v.DebutDate},
+		{"gender", &v.Gender},
+		{"height", &v.Height},
+		{"fanbase", &v.Fanbase},
+		{"status", &v.Status},
+		{"hashtags", &v.Hashtags},
+	}
+}
+
+func recordsEqual(a, b SourceRecord) bool {
+	if a.Key != b.Key || a.ID != b.ID || a.Link != b.Link || a.Modified != b.Modified {
+		return false
+	}
+	if !slices.Equal(a.Nicknames, b.Nicknames) {
+		return false
+	}
+	fa, fb := renderedFields(&a.VTuberRendered), renderedFields(&b.VTuberRendered)
+	for i := range fa {
+		if *fa[i].value != *fb[i].value {
+			return false
+		}
+	}
+	return true
+}
+
+// A vtuber built from the records of every source.
+type mergedVTuber struct {
+	VTuber
+	// Whether any of its records were added, removed or changed since
+	// they were stored. Vtubers merged from several records always are,
+	// as the precedence of their sources may have changed.
+	dirty bool
+}
+
+type sourcedRecord struct {
+	source int
+	record SourceRecord
+}
+
+// Merges the records fetched from each source, in order of precedence, into
+// vtubers. Each field is taken from the first record where it is set. The
+// records are returned along with the vtubers they were merged into, to be
+// stored for the next merge, and so are the IDs of vtubers that no longer
+// have any records.
+func mergeRecords(fetched [][]SourceRecord, stored []map[string]StoredRecord) ([]mergedVTuber, [][]StoredRecord, []int) {
+	var (
+		groups = make(map[int][]sourcedRecord)
+		order  []int
+		dirty  = make(map[int]bool)
+		// Lowest ID in use, below which new IDs are given.
+		lowest int
+	)
+	for _, records := range stored {
+		for _, r := range records {
+			lowest = min(lowest, r.VTuberID)
+		}
+	}
+
+	assigned := make([][]StoredRecord, len(fetched))
+	assign := func(source int, r SourceRecord, id int) {
+		if _, ok := groups[id]; !ok {
+			order = append(order, id)
+		}
+		groups[id] = append(groups[id], sourcedRecord{source, r})
+		assigned[source] = append(assigned[source], StoredRecord{r, id})
+
+		previous, ok := stored[source][r.Key]
+		if !ok || previous.VTuberID != id || !recordsEqual(previous.SourceRecord, r) {
+			dirty[id] = true
+		}
+	}
+
+	// Vtubers with IDs of their own by the channels they use.
+	channels := make(map[string][]int)
+	for source, records := range fetched {
+		for _, r := range records {
+			if r.ID <= 0 {
+				continue
+			}
+			if r.YouTubeID != "" && !slices.Contains(channels[r.YouTubeID], r.ID) {
+				channels[r.YouTubeID] = append(channels[r.YouTubeID], r.ID)
+			}
+			assign(source, r, r.ID)
+		}
+	}
+
+	// Shared channels can't tell which of their vtubers a record is for,
+	// so it is kept separate from all of them.
+	local := make(map[string]int)
+	for source, records := range fetched {
+		for _, r := range records {
+			if r.ID > 0 {
+				continue
+			}
+			if ids := channels[r.YouTubeID]; r.YouTubeID != "" && len(ids) == 1 {
+				assign(source, r, ids[0])
+				continue
+			}
+			if id, ok := local[r.YouTubeID]; ok && r.YouTubeID != "" {
+				assign(source, r, id)
+				continue
+			}
+
+			// A stored positive ID is kept when the record of its own is
+			// gone, so that the vtuber doesn't change IDs.
+			id := stored[source][r.Key].VTuberID
+			if _, taken := groups[id]; id == 0 || taken {
+				lowest--
+				id = lowest
+			}
+			if r.YouTubeID != "" {
+				local[r.YouTubeID] = id
+			}
+			assign(source, r, id)
+		}
+	}
+
+	// Records that are gone change the vtubers they were merged into,
+	// or remove them when they were the last.
+	var removed []int
+	for source, records := range stored {
+		current := make(map[string]bool, len(fetched[source]))
+		for _, r := range fetched[source] {
+			current[r.Key] = true
+		}
+		for key, r := range records {
+			if current[key] {
+				continue
+			}
+			dirty[r.VTuberID] = true
+			if _, ok := groups[r.VTuberID]; !ok && !slices.Contains(removed, r.VTuberID) {
+				removed = append(removed, r.VTuberID)
+			}
+		}
+	}
+	slices.Sort(removed)
+
+	vs := make([]mergedVTuber, 0, len(order))
+	for _, id := range order {
+		records := groups[id]
+		slices.SortStableFunc(records, func(a, b sourcedRecord) int {
+			return a.source - b.source
+		})
+
+		v := mergedVTuber{dirty: dirty[id] || len(records) > 1}
+		v.ID = id
+		fields := renderedFields(&v.VTuberRendered)
+		for _, r := range records {
+			if v.Link == "" {
+				v.Link = r.record.Link
+			}
+			if v.Modified == "" {
+				v.Modified = r.record.Modified
+			}
+			for i, f := range renderedFields(&r.record.VTuberRendered) {
+				if *fields[i].value == "" {
+					*fields[i].value = *f.value
+				}
+			}
+			for _, nickname := range r.record.Nicknames {
+				if !slices.Contains(v.Nicknames, nickname) {
+					v.Nicknames = append(v.Nicknames, nickname)
+				}
+			}
+		}
+		vs = append(vs, v)
+	}
+
+	return vs, assigned, removed
+}
+
+// FileSource is a talent source importing vtubers from a local CSV or JSON
+// file, chosen by its extension, to cover those missing from other sources.
+//
+// Fields are named like the columns of the store, such as english_name and
+// youtube_id. A CSV file names them in its header row and separates
+// nicknames with slashes, while a JSON file is an array of objects with a
+// nicknames array. Entries are keyed by their YouTube ID, or English name
+// when they have none, so either must be kept for the entry to keep its ID.
+type FileSource struct {
+	Path string
+}
+
+// Name is the file name, so that moving the file keeps its records.
+func (s *FileSource) Name() string {
+	return "file:" + filepath.Base(s.Path)
+}
+
+func (s *FileSource) Records(ctx context.Context, stored map[string]SourceRecord) ([]SourceRecord, error) {
+	var (
+		entries []map[string]any
+		err     error
+	)
+	switch ext := strings.ToLower(filepath.Ext(s.Path)); ext {
+	case ".csv":
+		entries, err = readCSVEntries(s.Path)
+	case ".json":
+		entries, err = readJSONEntries(s.Path)
+	default:
+		return nil, fmt.Errorf("unsupported file type %q", ext)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]SourceRecord, 0, len(entries))
+	keys := make(map[string]bool, len(entries))
+	for i, entry := range entries {
+		r, err := recordFromEntry(entry)
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: %w", i+1, err)
+		}
+		if keys[r.Key] {
+			return nil, fmt.Errorf("entry %d: duplicate %s", i+1, r.Key)
+		}
+		keys[r.Key] = true
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+func readCSVEntries(path string) ([]map[string]any, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	header, rows := rows[0], rows[1:]
+	entries := make([]map[string]any, len(rows))
+	for i, row := range rows {
+		entry := make(map[string]any, len(header))
+		for j, name := range header {
+			name = strings.TrimSpace(name)
+			if name != "nicknames" {
+				entry[name] = row[j]
+				continue
+			}
+			var nicknames []any
+			for _, nickname := range strings.Split(row[j], "/") {
+				nicknames = append(nicknames, nickname)
+			}
+			entry[name] = nicknames
+		}
+		entries[i] = entry
+	}
+	return entries, nil
+}
+
+func readJSONEntries(path string) ([]map[string]any, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []map[string]any
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func recordFromEntry(entry map[string]any) (SourceRecord, error) {
+	var r SourceRecord
+	fields := renderedFields(&r.VTuberRendered)
+
+	for name, value := range entry {
+		if name == "nicknames" {
+			values, ok := value.([]any)
+			if !ok {
+				return r, fmt.Errorf("nicknames must be a list")
+			}
+			for _, v := range values {
+				nickname, ok := v.(string)
+				if !ok {
+					return r, fmt.Errorf("nicknames must be strings")
+				}
+				if nickname = strings.TrimSpace(nickname); nickname != "" {
+					r.Nicknames = append(r.Nicknames, nickname)
+				}
+			}
+			continue
+		}
+
+		i := slices.IndexFunc(fields, func(f recordField) bool { return f.name == name })
+		if i < 0 {
+			return r, fmt.Errorf("unknown field %q", name)
+		}
+		s, ok := value.(string)
+		if !ok {
+			return r, fmt.Errorf("%s must be a string", name)
+		}
+		*fields[i].value = strings.TrimSpace(s)
+	}
+
+	if r.Hashtags != "" {
+		var tags []string
+		for _, tag := range strings.Fields(r.Hashtags) {
+			tags = append(tags, normalizeHashtag(tag))
+		}
+		r.Hashtags = strings.Join(tags, " ")
+	}
+
+	switch {
+	case r.YouTubeID != "":
+		r.Key = r.YouTubeID
+	case r.EnglishName != "":
+		r.Key = r.EnglishName
+	default:
+		return r, errors.New("missing both youtube_id and english_name")
+	}
+	return r, nil
+}
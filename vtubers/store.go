@@ -2,10 +2,17 @@ package vtubers
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"slices"
+	"strings"
 	"time"
+	"unicode"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/xoltia/botsu-oshi-stats/internal/migrate"
 )
 
 type Store struct {
@@ -28,11 +35,106 @@ func CreateStore(ctx context.Context, db *sqlx.DB) (*Store, error) {
 				gender         TEXT NOT NULL,
 				fanbase        TEXT NOT NULL,
 				status         TEXT NOT NULL,
+				hashtags       TEXT NOT NULL DEFAULT '',
 				id             INTEGER PRIMARY KEY,
 				link           TEXT NOT NULL NOT NULL,
 				modified       TEXT NOT NULL
 			);
 
+			CREATE TABLE IF NOT EXISTS vtuber_aliases (
+				vtuber_id INTEGER NOT NULL,
+				alias     TEXT NOT NULL,
+				source    TEXT NOT NULL,
+				filter    TEXT NOT NULL,
+
+				PRIMARY KEY (vtuber_id, alias)
+			);
+
+			CREATE TABLE IF NOT EXISTS detection_rules (
+				id        INTEGER PRIMARY KEY AUTOINCREMENT,
+				vtuber_id INTEGER NOT NULL,
+				name      TEXT NOT NULL,
+				kind      TEXT NOT NULL,
+				context   TEXT NOT NULL,
+				pattern   TEXT NOT NULL
+			);
+
+			CREATE TABLE IF NOT EXISTS source_records (
+				source         TEXT NOT NULL,
+				key            TEXT NOT NULL,
+				id             INTEGER NOT NULL,
+				vtuber_id      INTEGER NOT NULL,
+				link           TEXT NOT NULL,
+				modified       TEXT NOT NULL,
+				youtube_id     TEXT NOT NULL,
+				youtube_handle TEXT NOT NULL,
+				picture_url    TEXT NOT NULL,
+				original_name  TEXT NOT NULL,
+				english_name   TEXT NOT NULL,
+				oshi_mark      TEXT NOT NULL,
+				zodiac         TEXT NOT NULL,
+				affiliation    TEXT NOT NULL,
+				birthday       TEXT NOT NULL,
+				debut_date     TEXT NOT NULL,
+				gender         TEXT NOT NULL,
+				height         TEXT NOT NULL,
+				fanbase        TEXT NOT NULL,
+				status         TEXT NOT NULL,
+				hashtags       TEXT NOT NULL,
+				nicknames      TEXT NOT NULL,
+
+				PRIMARY KEY (source, key)
+			);
+
+			CREATE TABLE IF NOT EXISTS pending_source_records (
+				source         TEXT NOT NULL,
+				key            TEXT NOT NULL,
+				id             INTEGER NOT NULL,
+				vtuber_id      INTEGER NOT NULL,
+				link           TEXT NOT NULL,
+				modified       TEXT NOT NULL,
+				youtube_id     TEXT NOT NULL,
+				youtube_handle TEXT NOT NULL,
+				picture_url    TEXT NOT NULL,
+				original_name  TEXT NOT NULL,
+				english_name   TEXT NOT NULL,
+				oshi_mark      TEXT NOT NULL,
+				zodiac         TEXT NOT NULL,
+				affiliation    TEXT NOT NULL,
+				birthday       TEXT NOT NULL,
+				debut_date     TEXT NOT NULL,
+				gender         TEXT NOT NULL,
+				height         TEXT NOT NULL,
+				fanbase        TEXT NOT NULL,
+				status         TEXT NOT NULL,
+				hashtags       TEXT NOT NULL,
+				nicknames      TEXT NOT NULL,
+
+				PRIMARY KEY (source, key)
+			);
+
+			CREATE TABLE IF NOT EXISTS source_progress (
+				source TEXT NOT NULL PRIMARY KEY,
+				offset INTEGER NOT NULL
+			);
+
+			CREATE TABLE IF NOT EXISTS hololist_pages (
+				offset        INTEGER NOT NULL,
+				per_page      INTEGER NOT NULL,
+				etag          TEXT NOT NULL,
+				last_modified TEXT NOT NULL,
+				posts         BLOB NOT NULL,
+
+				PRIMARY KEY (offset, per_page)
+			);
+
+			CREATE TABLE IF NOT EXISTS dictionary (
+				id        INTEGER NOT NULL PRIMARY KEY CHECK (id = 0),
+				version   INTEGER NOT NULL,
+				update_id INTEGER NOT NULL,
+				data      BLOB NOT NULL
+			);
+
 			CREATE TABLE IF NOT EXISTS vtuber_channels (
 				id         TEXT NOT NULL PRIMARY KEY,
 				name       TEXT NOT NULL,
@@ -42,14 +144,57 @@ func CreateStore(ctx context.Context, db *sqlx.DB) (*Store, error) {
 			CREATE TABLE IF NOT EXISTS update_history (
 				timestamp TIMESTAMP NOT NULL
 			);
+
+			CREATE TABLE IF NOT EXISTS update_history_vtubers (
+				update_id INTEGER NOT NULL,
+				vtuber_id INTEGER NOT NULL,
+
+				PRIMARY KEY (update_id, vtuber_id)
+			);
+
+			CREATE TABLE IF NOT EXISTS pending_changes (
+				vtuber_id INTEGER NOT NULL PRIMARY KEY
+			);
 		`)
 	if err != nil {
 		return nil, err
 	}
+	_, err = migrate.AddColumn(ctx, db, "vtubers", "hashtags", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return nil, err
+	}
+	err = seedSourceRecords(ctx, db)
+	if err != nil {
+		return nil, err
+	}
 	store := &Store{db}
 	return store, nil
 }
 
+// Stores vtubers scraped before source records were kept as hololist
+// records, so that they aren't all scraped again by the next update.
+func seedSourceRecords(ctx context.Context, db *sqlx.DB) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO source_records
+		SELECT
+			$1, CAST(id AS TEXT), id, id, link, modified,
+			youtube_id, youtube_handle, picture_url, original_name, english_name,
+			oshi_mark, zodiac, affiliation, birthday, debut_date, gender, '',
+			fanbase, status, hashtags,
+			COALESCE((
+				SELECT group_concat(alias, char(10))
+				FROM vtuber_aliases
+				WHERE vtuber_id = vtubers.id AND source = $2
+			), '')
+		FROM vtubers
+		WHERE id > 0 AND NOT EXISTS (SELECT 1 FROM source_records)
+	`, HololistSourceName, AliasSourceScraped)
+	if err != nil {
+		return fmt.Errorf("seed source records: %w", err)
+	}
+	return nil
+}
+
 func (s *Store) CreateOrUpdateChannel(ctx context.Context, c Channel) error {
 	_, err := s.db.NamedExecContext(ctx, `
 			INSERT INTO vtuber_channels (
@@ -72,7 +217,11 @@ func (s *Store) CreateOrUpdateChannel(ctx context.Context, c Channel) error {
 }
 
 func (s *Store) CreateOrUpdate(ctx context.Context, v VTuber) error {
-	_, err := s.db.NamedExecContext(ctx, `
+	return upsertVTuber(ctx, s.db, v)
+}
+
+func upsertVTuber(ctx context.Context, e sqlx.ExtContext, v VTuber) error {
+	_, err := sqlx.NamedExecContext(ctx, e, `
 			INSERT INTO vtubers (
 				youtube_id,
 				youtube_handle,
@@ -86,6 +235,7 @@ func (s *Store) CreateOrUpdate(ctx context.Context, v VTuber) error {
 				gender,
 				fanbase,
 				status,
+				hashtags,
 				id,
 				link,
 				modified,
@@ -104,6 +254,7 @@ func (s *Store) CreateOrUpdate(ctx context.Context, v VTuber) error {
 				:gender,
 				:fanbase,
 				:status,
+				:hashtags,
 				:id,
 				:link,
 				:modified,
@@ -123,6 +274,7 @@ func (s *Store) CreateOrUpdate(ctx context.Context, v VTuber) error {
 				gender = :gender,
 				fanbase = :fanbase,
 				status = :status,
+				hashtags = :hashtags,
 				id = :id,
 				link = :link,
 				modified = :modified,
@@ -131,18 +283,18 @@ func (s *Store) CreateOrUpdate(ctx context.Context, v VTuber) error {
 	return err
 }
 
-func (s *Store) FindByID(ctx context.Context, id int) (v VTuber, err error) {
-	err = s.db.GetContext(ctx, &v, "SELECT * FROM vtubers WHERE id = $1", id)
+func (s *Store) GetAll(ctx context.Context) (vs []VTuber, err error) {
+	err = s.db.SelectContext(ctx, &vs, "SELECT * FROM vtubers")
 	return
 }
 
-func (s *Store) FindByName(ctx context.Context, name string) (v VTuber, err error) {
-	err = s.db.GetContext(ctx, &v, "SELECT * FROM vtubers WHERE original_name = $1 or english_name = $1", name)
+func (s *Store) FindByID(ctx context.Context, id int) (v VTuber, err error) {
+	err = s.db.GetContext(ctx, &v, "SELECT * FROM vtubers WHERE id = $1", id)
 	return
 }
 
-func (s *Store) FindByYouTubeID(ctx context.Context, id string) (v VTuber, err error) {
-	err = s.db.GetContext(ctx, &v, "SELECT * FROM vtubers WHERE youtube_id = $1", id)
+func (s *Store) FindByName(ctx context.Context, name string) (v VTuber, err error) {
+	err = s.db.GetContext(ctx, &v, "SELECT * FROM vtubers WHERE original_name = $1 or english_name = $1 COLLATE NOCASE", name)
 	return
 }
 
@@ -208,11 +360,476 @@ func (s *Store) GetAllNames(ctx context.Context) (names []Names, err error) {
 	return
 }
 
-func (s *Store) LogUpdate(ctx context.Context) error {
-	_, err := s.db.ExecContext(ctx, "INSERT INTO update_history (timestamp) VALUES (CURRENT_TIMESTAMP)")
+// GetAliases returns the aliases of every vtuber.
+func (s *Store) GetAliases(ctx context.Context) (aliases []Alias, err error) {
+	err = s.db.SelectContext(ctx, &aliases, "SELECT * FROM vtuber_aliases ORDER BY vtuber_id, alias")
+	return
+}
+
+func (s *Store) GetAliasesForVTuber(ctx context.Context, vtuberID int) (aliases []Alias, err error) {
+	err = s.db.SelectContext(ctx, &aliases, "SELECT * FROM vtuber_aliases WHERE vtuber_id = $1 ORDER BY alias", vtuberID)
+	return
+}
+
+// AddAlias creates or replaces an alias.
+func (s *Store) AddAlias(ctx context.Context, a Alias) error {
+	_, err := s.db.NamedExecContext(ctx, `
+		INSERT INTO vtuber_aliases (vtuber_id, alias, source, filter)
+		VALUES (:vtuber_id, :alias, :source, :filter)
+		ON CONFLICT (vtuber_id, alias) DO UPDATE
+		SET source = excluded.source,
+			filter = excluded.filter
+	`, a)
+	return err
+}
+
+// RemoveAlias deletes an alias, reporting whether it existed.
+func (s *Store) RemoveAlias(ctx context.Context, vtuberID int, alias string) (bool, error) {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM vtuber_aliases WHERE vtuber_id = $1 AND alias = $2", vtuberID, alias)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// GetRules returns the detection rules of every vtuber.
+func (s *Store) GetRules(ctx context.Context) (rules []Rule, err error) {
+	err = s.db.SelectContext(ctx, &rules, "SELECT * FROM detection_rules ORDER BY vtuber_id, id")
+	return
+}
+
+func (s *Store) GetRulesForVTuber(ctx context.Context, vtuberID int) (rules []Rule, err error) {
+	err = s.db.SelectContext(ctx, &rules, "SELECT * FROM detection_rules WHERE vtuber_id = $1 ORDER BY id", vtuberID)
+	return
+}
+
+// AddRule validates and creates a rule, returning its ID.
+func (s *Store) AddRule(ctx context.Context, r Rule) (int64, error) {
+	if err := r.Validate(); err != nil {
+		return 0, err
+	}
+	res, err := s.db.NamedExecContext(ctx, `
+		INSERT INTO detection_rules (vtuber_id, name, kind, context, pattern)
+		VALUES (:vtuber_id, :name, :kind, :context, :pattern)
+	`, r)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// RemoveRule deletes a rule of the vtuber, reporting whether it existed.
+func (s *Store) RemoveRule(ctx context.Context, vtuberID int, id int64) (bool, error) {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM detection_rules WHERE vtuber_id = $1 AND id = $2", vtuberID, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// SetScrapedAliases replaces the scraped aliases of a vtuber, leaving admin
+// aliases untouched, and reports whether anything changed. Aliases that
+// already exist as admin aliases are skipped.
+func (s *Store) SetScrapedAliases(ctx context.Context, vtuberID int, aliases []string) (bool, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	changed, err := setScrapedAliases(ctx, tx, vtuberID, aliases)
+	if err != nil {
+		return false, err
+	}
+	return changed, tx.Commit()
+}
+
+func setScrapedAliases(ctx context.Context, tx *sqlx.Tx, vtuberID int, aliases []string) (bool, error) {
+	var existing []string
+	err := tx.SelectContext(ctx, &existing, `
+		SELECT alias FROM vtuber_aliases
+		WHERE vtuber_id = $1 AND source = $2
+		ORDER BY alias
+	`, vtuberID, AliasSourceScraped)
+	if err != nil {
+		return false, fmt.Errorf("select: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM vtuber_aliases WHERE vtuber_id = $1 AND source = $2", vtuberID, AliasSourceScraped)
+	if err != nil {
+		return false, fmt.Errorf("delete: %w", err)
+	}
+
+	for _, alias := range aliases {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO vtuber_aliases (vtuber_id, alias, source, filter)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT DO NOTHING
+		`, vtuberID, alias, AliasSourceScraped, defaultAliasFilter(alias))
+		if err != nil {
+			return false, fmt.Errorf("insert: %w", err)
+		}
+	}
+
+	var current []string
+	err = tx.SelectContext(ctx, &current, `
+		SELECT alias FROM vtuber_aliases
+		WHERE vtuber_id = $1 AND source = $2
+		ORDER BY alias
+	`, vtuberID, AliasSourceScraped)
+	if err != nil {
+		return false, fmt.Errorf("select: %w", err)
+	}
+
+	return !slices.Equal(existing, current), nil
+}
+
+// SaveVTuber creates or updates a vtuber along with its scraped aliases,
+// taken from its nicknames, and reports whether detection relevant data
+// changed. Changed vtubers are kept as pending changes in the same
+// transaction, so that they are logged by the next update even if the
+// current one fails before logging them.
+func (s *Store) SaveVTuber(ctx context.Context, v VTuber) (bool, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var existing VTuber
+	err = tx.GetContext(ctx, &existing, "SELECT * FROM vtubers WHERE id = $1", v.ID)
+	exists := err == nil
+	if !exists && !errors.Is(err, sql.ErrNoRows) {
+		return false, fmt.Errorf("select: %w", err)
+	}
+
+	if err := upsertVTuber(ctx, tx, v); err != nil {
+		return false, fmt.Errorf("upsert: %w", err)
+	}
+	aliasesChanged, err := setScrapedAliases(ctx, tx, v.ID, v.Nicknames)
+	if err != nil {
+		return false, fmt.Errorf("set aliases: %w", err)
+	}
+
+	changed := !exists || aliasesChanged || detectionFieldsChanged(existing, v)
+	if changed {
+		_, err := tx.ExecContext(ctx, "INSERT INTO pending_changes (vtuber_id) VALUES ($1) ON CONFLICT DO NOTHING", v.ID)
+		if err != nil {
+			return false, fmt.Errorf("insert pending change: %w", err)
+		}
+	}
+
+	return changed, tx.Commit()
+}
+
+// DeleteVTuber deletes a vtuber along with its scraped aliases, reporting
+// whether it existed. Admin aliases and rules are kept in case the vtuber
+// returns. A deleted vtuber is kept as a pending change like in SaveVTuber,
+// so that its attributions are removed once the update is logged.
+func (s *Store) DeleteVTuber(ctx context.Context, id int) (bool, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "DELETE FROM vtubers WHERE id = $1", id)
+	if err != nil {
+		return false, fmt.Errorf("delete: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if n == 0 {
+		return false, nil
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM vtuber_aliases WHERE vtuber_id = $1 AND source = $2", id, AliasSourceScraped)
+	if err != nil {
+		return false, fmt.Errorf("delete aliases: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO pending_changes (vtuber_id) VALUES ($1) ON CONFLICT DO NOTHING", id)
+	if err != nil {
+		return false, fmt.Errorf("insert pending change: %w", err)
+	}
+
+	return true, tx.Commit()
+}
+
+// Chooses the filter for an alias by whether it is written in latin script.
+func defaultAliasFilter(alias string) AliasFilter {
+	for _, r := range alias {
+		if r > unicode.MaxASCII {
+			return AliasFilterOriginal
+		}
+	}
+	return AliasFilterEnglish
+}
+
+// LogUpdate records a completed update along with the IDs of vtubers
+// whose detection relevant data was created or modified by it, including
+// the pending changes saved since the last logged update.
+func (s *Store) LogUpdate(ctx context.Context, changedIDs []int) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "INSERT INTO update_history (timestamp) VALUES (CURRENT_TIMESTAMP)")
+	if err != nil {
+		return err
+	}
+	updateID, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	for _, id := range changedIDs {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO update_history_vtubers (update_id, vtuber_id)
+			VALUES (?, ?)
+			ON CONFLICT DO NOTHING
+		`, updateID, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO update_history_vtubers (update_id, vtuber_id)
+		SELECT ?, vtuber_id FROM pending_changes
+		WHERE true
+		ON CONFLICT DO NOTHING
+	`, updateID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM pending_changes")
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetChangedSince returns the IDs of vtubers changed by updates logged after
+// the given update ID, as well as the ID of the latest logged update.
+// Passing zero considers all updates.
+func (s *Store) GetChangedSince(ctx context.Context, updateID int64) (ids []int, latest int64, err error) {
+	latest, err = s.LatestUpdateID(ctx)
+	if err != nil {
+		err = fmt.Errorf("latest: %w", err)
+		return
+	}
+
+	err = s.db.SelectContext(ctx, &ids, `
+		SELECT DISTINCT vtuber_id
+		FROM update_history_vtubers
+		WHERE update_id > ? AND update_id <= ?
+	`, updateID, latest)
+	if err != nil {
+		err = fmt.Errorf("select: %w", err)
+	}
+	return
+}
+
+// LatestUpdateID returns the ID of the latest logged update, or zero if
+// there are none.
+func (s *Store) LatestUpdateID(ctx context.Context) (id int64, err error) {
+	err = s.db.GetContext(ctx, &id, "SELECT COALESCE(MAX(rowid), 0) FROM update_history")
+	return
+}
+
+// Source records are stored with their nicknames on separate lines.
+type sourceRecordRow struct {
+	Source string `db:"source"`
+	StoredRecord
+	Nicknames string `db:"nicknames"`
+}
+
+// GetSourceNames returns the names of the sources with stored records.
+func (s *Store) GetSourceNames(ctx context.Context) ([]string, error) {
+	names := make([]string, 0)
+	err := s.db.SelectContext(ctx, &names, "SELECT DISTINCT source FROM source_records ORDER BY source")
+	return names, err
+}
+
+// GetSourceRecords returns the records stored by the last update for a source.
+func (s *Store) GetSourceRecords(ctx context.Context, source string) ([]StoredRecord, error) {
+	return s.getSourceRecords(ctx, "source_records", source)
+}
+
+// GetPendingRecords returns the records a source added while an update was
+// in progress, which is ended by setting the records of the source.
+func (s *Store) GetPendingRecords(ctx context.Context, source string) ([]SourceRecord, error) {
+	stored, err := s.getSourceRecords(ctx, "pending_source_records", source)
+	if err != nil {
+		return nil, err
+	}
+	records := make([]SourceRecord, len(stored))
+	for i, r := range stored {
+		records[i] = r.SourceRecord
+	}
+	return records, nil
+}
+
+func (s *Store) getSourceRecords(ctx context.Context, table, source string) ([]StoredRecord, error) {
+	var rows []sourceRecordRow
+	err := s.db.SelectContext(ctx, &rows, fmt.Sprintf("SELECT * FROM %s WHERE source = $1 ORDER BY key", table), source)
+	if err != nil {
+		return nil, fmt.Errorf("select: %w", err)
+	}
+
+	records := make([]StoredRecord, len(rows))
+	for i, row := range rows {
+		records[i] = row.StoredRecord
+		if row.Nicknames != "" {
+			records[i].Nicknames = strings.Split(row.Nicknames, "\n")
+		}
+	}
+	return records, nil
+}
+
+func insertSourceRecord(ctx context.Context, e sqlx.ExtContext, table, source string, r StoredRecord) error {
+	row := sourceRecordRow{source, r, strings.Join(r.Nicknames, "\n")}
+	_, err := sqlx.NamedExecContext(ctx, e, fmt.Sprintf(`
+		INSERT OR REPLACE INTO %s (
+			source, key, id, vtuber_id, link, modified,
+			youtube_id, youtube_handle, picture_url, original_name, english_name,
+			oshi_mark, zodiac, affiliation, birthday, debut_date, gender, height,
+			fanbase, status, hashtags, nicknames
+		)
+		VALUES (
+			:source, :key, :id, :vtuber_id, :link, :modified,
+			:youtube_id, :youtube_handle, :picture_url, :original_name, :english_name,
+			:oshi_mark, :zodiac, :affiliation, :birthday, :debut_date, :gender, :height,
+			:fanbase, :status, :hashtags, :nicknames
+		)
+	`, table), row)
+	return err
+}
+
+// AddPendingRecord keeps a record fetched by a source during an update,
+// so that it isn't fetched again if the update is resumed.
+func (s *Store) AddPendingRecord(ctx context.Context, source string, r SourceRecord) error {
+	return insertSourceRecord(ctx, s.db, "pending_source_records", source, StoredRecord{SourceRecord: r})
+}
+
+// SetSourceRecords replaces the stored records of a source, ending the
+// update in progress by removing its pending records and progress.
+func (s *Store) SetSourceRecords(ctx context.Context, source string, records []StoredRecord) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, table := range []string{"source_records", "pending_source_records", "source_progress"} {
+		_, err = tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE source = $1", table), source)
+		if err != nil {
+			return fmt.Errorf("delete %s: %w", table, err)
+		}
+	}
+
+	for _, r := range records {
+		if err := insertSourceRecord(ctx, tx, "source_records", source, r); err != nil {
+			return fmt.Errorf("insert: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetSourceProgress returns how far a source got in an update in progress,
+// or zero if there is none.
+func (s *Store) GetSourceProgress(ctx context.Context, source string) (offset int, err error) {
+	err = s.db.GetContext(ctx, &offset, "SELECT COALESCE(MAX(offset), 0) FROM source_progress WHERE source = $1", source)
+	return
+}
+
+func (s *Store) SetSourceProgress(ctx context.Context, source string, offset int) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO source_progress (source, offset)
+		VALUES ($1, $2)
+		ON CONFLICT (source) DO UPDATE
+		SET offset = excluded.offset
+	`, source, offset)
+	return err
+}
+
+// Posts pages are stored with their posts encoded as JSON.
+type postsPageRow struct {
+	Offset       int    `db:"offset"`
+	Limit        int    `db:"per_page"`
+	ETag         string `db:"etag"`
+	LastModified string `db:"last_modified"`
+	Posts        []byte `db:"posts"`
+}
+
+// GetPostsPage returns the hololist posts page last fetched at the offset
+// with the same limit or sql.ErrNoRows if there is none.
+func (s *Store) GetPostsPage(ctx context.Context, offset, limit int) (PostsPage, error) {
+	var row postsPageRow
+	err := s.db.GetContext(ctx, &row, "SELECT * FROM hololist_pages WHERE offset = $1 AND per_page = $2", offset, limit)
+	if err != nil {
+		return PostsPage{}, err
+	}
+
+	page := PostsPage{
+		Offset:       row.Offset,
+		Limit:        row.Limit,
+		ETag:         row.ETag,
+		LastModified: row.LastModified,
+	}
+	if err := json.Unmarshal(row.Posts, &page.Posts); err != nil {
+		return PostsPage{}, fmt.Errorf("decode posts: %w", err)
+	}
+	return page, nil
+}
+
+func (s *Store) SavePostsPage(ctx context.Context, p PostsPage) error {
+	posts, err := json.Marshal(p.Posts)
+	if err != nil {
+		return fmt.Errorf("encode posts: %w", err)
+	}
+	_, err = s.db.NamedExecContext(ctx, `
+		INSERT OR REPLACE INTO hololist_pages (offset, per_page, etag, last_modified, posts)
+		VALUES (:offset, :per_page, :etag, :last_modified, :posts)
+	`, postsPageRow{p.Offset, p.Limit, p.ETag, p.LastModified, posts})
 	return err
 }
 
+// StoredDictionary is an encoded name dictionary built from the store.
+type StoredDictionary struct {
+	// Version of the detector that built the dictionary.
+	Version int `db:"version"`
+	// Latest update when the dictionary was built.
+	UpdateID int64  `db:"update_id"`
+	Data     []byte `db:"data"`
+}
+
+func (s *Store) SaveDictionary(ctx context.Context, d StoredDictionary) error {
+	_, err := s.db.NamedExecContext(ctx, `
+		INSERT INTO dictionary (id, version, update_id, data)
+		VALUES (0, :version, :update_id, :data)
+		ON CONFLICT (id) DO UPDATE
+		SET version = excluded.version,
+			update_id = excluded.update_id,
+			data = excluded.data
+	`, d)
+	return err
+}
+
+// GetDictionary returns the stored dictionary or sql.ErrNoRows if there is none.
+func (s *Store) GetDictionary(ctx context.Context) (d StoredDictionary, err error) {
+	err = s.db.GetContext(ctx, &d, "SELECT version, update_id, data FROM dictionary WHERE id = 0")
+	return
+}
+
 func (s *Store) LastUpdate(ctx context.Context) (time.Time, error) {
 	var row struct {
 		Timestamp time.Time `db:"timestamp"`
@@ -2,7 +2,6 @@ package vtubers
 
 import (
 	"context"
-	"database/sql"
 	"errors"
 	"fmt"
 	"slices"
@@ -13,73 +12,140 @@ import (
 
 var (
 	ErrBackoff = errors.New("backoff")
+	// Returned when a source has fewer than half of the records it had,
+	// which is more likely a failed fetch than vtubers being removed.
+	ErrTooFewRecords = errors.New("too few records")
 )
 
 type UpdateOptions struct {
-	ScraperBatchSize   int
-	MaxRequestAttempts int
-	GoogleAPIKey       string
-	ChannelsOnly       bool
-}
-
-func (o *UpdateOptions) applyDefaults() {
-	if o.ScraperBatchSize == 0 {
-		o.ScraperBatchSize = 100
-	}
-	if o.MaxRequestAttempts == 0 {
-		o.MaxRequestAttempts = 5
-	}
+	GoogleAPIKey string
+	// Additional options for the YouTube Data API client, such as its endpoint.
+	YouTubeOptions []option.ClientOption
+	ChannelsOnly   bool
+	// Remove the vtubers of sources that have far fewer records than
+	// before, or that are no longer given, instead of failing the update.
+	AllowRemovals bool
 }
 
 type Updater struct {
 	Options UpdateOptions
 	Store   *Store
-	Scraper *HololistScraper
+	// Sources of vtuber data in order of precedence, each field of a
+	// vtuber being taken from the first source that has it set.
+	Sources []TalentSource
 }
 
-func (u *Updater) updateHololistData(ctx context.Context) error {
-	u.Scraper.Reset()
+// Reports whether any of the fields used for detection differ.
+func detectionFieldsChanged(a, b VTuber) bool {
+	return a.YouTubeID != b.YouTubeID ||
+		a.YouTubeHandle != b.YouTubeHandle ||
+		a.OriginalName != b.OriginalName ||
+		a.EnglishName != b.EnglishName ||
+		a.Hashtags != b.Hashtags
+}
 
-	for {
-		page, err := u.Scraper.NextPosts(ctx, u.Options.ScraperBatchSize, u.Options.MaxRequestAttempts)
+// Merges the records of every source into the store. Vtubers that were
+// created or had detection relevant fields modified are saved as pending
+// changes for the next logged update.
+func (u *Updater) updateSourceData(ctx context.Context) error {
+	names, err := u.Store.GetSourceNames(ctx)
+	if err != nil {
+		return fmt.Errorf("get source names: %w", err)
+	}
+	// Sources that are no longer given have no records, so that the
+	// fields and vtubers only they provided are removed.
+	sources := slices.Clone(u.Sources)
+	for _, name := range names {
+		given := slices.ContainsFunc(u.Sources, func(s TalentSource) bool {
+			return s.Name() == name
+		})
+		if !given {
+			sources = append(sources, removedSource(name))
+		}
+	}
+
+	var (
+		fetched = make([][]SourceRecord, len(sources))
+		stored  = make([]map[string]StoredRecord, len(sources))
+	)
+	for i, source := range sources {
+		records, err := u.Store.GetSourceRecords(ctx, source.Name())
 		if err != nil {
-			if errors.Is(err, ErrExhaustedPosts) {
-				break
-			}
-			return err
+			return fmt.Errorf("get %s records: %w", source.Name(), err)
 		}
 
-		for _, meta := range page {
-			existing, err := u.Store.FindByID(ctx, meta.ID)
-			if err == nil && existing.Modified == meta.Modified {
-				continue
-			} else if !errors.Is(err, sql.ErrNoRows) {
-				return err
-			}
+		stored[i] = make(map[string]StoredRecord, len(records))
+		previous := make(map[string]SourceRecord, len(records))
+		for _, r := range records {
+			stored[i][r.Key] = r
+			previous[r.Key] = r.SourceRecord
+		}
 
-			rendered, err := u.Scraper.GetRenderedPost(ctx, meta.Link, u.Options.MaxRequestAttempts)
-			if err != nil {
-				return err
-			}
-			err = u.Store.CreateOrUpdate(ctx, VTuber{rendered, meta})
-			if err != nil {
-				return err
-			}
+		fetched[i], err = source.Records(ctx, previous)
+		if err != nil {
+			return fmt.Errorf("%s: %w", source.Name(), err)
+		}
+		if !u.Options.AllowRemovals && len(fetched[i])*2 < len(records) {
+			return fmt.Errorf("%s: %w: %d of %d stored", source.Name(), ErrTooFewRecords, len(fetched[i]), len(records))
+		}
+	}
+
+	vs, assigned, removed := mergeRecords(fetched, stored)
+	for _, v := range vs {
+		if !v.dirty {
+			continue
+		}
+		if _, err := u.Store.SaveVTuber(ctx, v.VTuber); err != nil {
+			return fmt.Errorf("save %d: %w", v.ID, err)
+		}
+	}
+	for _, id := range removed {
+		if _, err := u.Store.DeleteVTuber(ctx, id); err != nil {
+			return fmt.Errorf("delete %d: %w", id, err)
+		}
+	}
+
+	// Stored last, so that vtubers are written again if anything fails before.
+	for i, source := range sources {
+		if err := u.Store.SetSourceRecords(ctx, source.Name(), assigned[i]); err != nil {
+			return fmt.Errorf("set %s records: %w", source.Name(), err)
 		}
 	}
 
 	return nil
 }
 
-func (u *Updater) Update(ctx context.Context) error {
-	u.Options.applyDefaults()
+// A source with stored records that is no longer given.
+type removedSource string
 
+func (s removedSource) Name() string {
+	return string(s)
+}
+
+func (s removedSource) Records(ctx context.Context, stored map[string]SourceRecord) ([]SourceRecord, error) {
+	return nil, nil
+}
+
+// Update merges the data of every source into the store, logging the update
+// and rebuilding the dictionary before channel data is updated, which has
+// no effect on detection.
+func (u *Updater) Update(ctx context.Context) error {
 	if !u.Options.ChannelsOnly {
-		err := u.updateHololistData(ctx)
-		if err != nil {
-			return fmt.Errorf("hololist update: %w", err)
+		if err := u.updateSourceData(ctx); err != nil {
+			return fmt.Errorf("source update: %w", err)
 		}
 	}
+
+	err := u.Store.LogUpdate(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("log update: %w", err)
+	}
+
+	err = u.Store.RebuildDictionary(ctx)
+	if err != nil {
+		return fmt.Errorf("rebuild dictionary: %w", err)
+	}
+
 	youtubeIDs, err := u.Store.GetAllScrapedYouTubeIDs(ctx)
 	if err != nil {
 		return fmt.Errorf("load ids: %w", err)
@@ -90,11 +156,6 @@ func (u *Updater) Update(ctx context.Context) error {
 		return fmt.Errorf("channel data update: %w", err)
 	}
 
-	err = u.Store.LogUpdate(ctx)
-	if err != nil {
-		return fmt.Errorf("log update: %w", err)
-	}
-
 	return nil
 }
 
@@ -104,7 +165,8 @@ func (u *Updater) updateChannelData(ctx context.Context, ids []string) error {
 		return nil
 	}
 
-	service, err := youtube.NewService(ctx, option.WithAPIKey(apiKey))
+	opts := append([]option.ClientOption{option.WithAPIKey(apiKey)}, u.Options.YouTubeOptions...)
+	service, err := youtube.NewService(ctx, opts...)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,321 @@
+package vtubers_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/xoltia/botsu-oshi-stats/vtubers"
+	"google.golang.org/api/option"
+)
+
+type staticSource []vtubers.SourceRecord
+
+func (s staticSource) Name() string { return "static" }
+
+func (s staticSource) Records(ctx context.Context, stored map[string]vtubers.SourceRecord) ([]vtubers.SourceRecord, error) {
+	return s, nil
+}
+
+func createTestStore(t *testing.T) *vtubers.Store {
+	t.Helper()
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	// Every connection would open a database of its own.
+	db.SetMaxOpenConns(1)
+
+	store, err := vtubers.CreateStore(context.Background(), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestUpdateMergesSources(t *testing.T) {
+	ctx := context.Background()
+	store := createTestStore(t)
+
+	listed := staticSource{{
+		Key:      "1",
+		ID:       1,
+		Modified: "2024-01-01",
+		VTuberRendered: vtubers.VTuberRendered{
+			YouTubeID:   "UC0000000000000000000001",
+			EnglishName: "Usada Pekora",
+		},
+	}}
+	roster := &vtubers.FileSource{Path: filepath.Join(t.TempDir(), "roster.json")}
+	err := os.WriteFile(roster.Path, []byte(`[
+		{"youtube_id": "UC0000000000000000000001", "english_name": "Pekora", "hashtags": "＃ぺこらいぶ"},
+		{"youtube_id": "UC0000000000000000000009", "english_name": "Indie Person", "nicknames": ["Indie"]}
+	]`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(id int, englishName, hashtags string) {
+		t.Helper()
+		v, err := store.FindByID(ctx, id)
+		if err != nil {
+			t.Fatalf("Find %d: %s", id, err)
+		}
+		if v.EnglishName != englishName || v.Hashtags != hashtags {
+			t.Errorf("Expected %d to be %q %q got %q %q", id, englishName, hashtags, v.EnglishName, v.Hashtags)
+		}
+	}
+
+	updater := vtubers.Updater{Store: store, Sources: []vtubers.TalentSource{listed, roster}}
+	if err := updater.Update(ctx); err != nil {
+		t.Fatal(err)
+	}
+	check(1, "Usada Pekora", "#ぺこらいぶ")
+	check(-1, "Indie Person", "")
+
+	aliases, err := store.GetAliasesForVTuber(ctx, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(aliases) != 1 || aliases[0].Alias != "Indie" {
+		t.Errorf("Expected alias Indie got %v", aliases)
+	}
+
+	if err := updater.Update(ctx); err != nil {
+		t.Fatal(err)
+	}
+	changed, _, err := store.GetChangedSince(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changed) != 0 {
+		t.Errorf("Expected nothing changed by the same records got %v", changed)
+	}
+	check(-1, "Indie Person", "")
+
+	updater.Sources = []vtubers.TalentSource{roster, listed}
+	if err := updater.Update(ctx); err != nil {
+		t.Fatal(err)
+	}
+	check(1, "Pekora", "#ぺこらいぶ")
+	check(-1, "Indie Person", "")
+}
+
+func TestUpdateLogsChangesWhenChannelsFail(t *testing.T) {
+	ctx := context.Background()
+	store := createTestStore(t)
+
+	youtube := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error": {"code": 400, "message": "bad request"}}`, http.StatusBadRequest)
+	}))
+	defer youtube.Close()
+
+	updater := vtubers.Updater{
+		Store: store,
+		Sources: []vtubers.TalentSource{staticSource{{
+			Key:            "1",
+			ID:             1,
+			VTuberRendered: vtubers.VTuberRendered{YouTubeID: "UC0000000000000000000001", EnglishName: "Usada Pekora"},
+		}}},
+		Options: vtubers.UpdateOptions{
+			GoogleAPIKey:   "key",
+			YouTubeOptions: []option.ClientOption{option.WithEndpoint(youtube.URL)},
+		},
+	}
+	if err := updater.Update(ctx); err == nil {
+		t.Fatal("Expected the channel data update to fail")
+	}
+
+	changed, _, err := store.GetChangedSince(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(changed, []int{1}) {
+		t.Errorf("Expected [1] changed got %v", changed)
+	}
+}
+
+func TestUpdateRemovesVTubersWithoutRecords(t *testing.T) {
+	ctx := context.Background()
+	store := createTestStore(t)
+
+	roster := &vtubers.FileSource{Path: filepath.Join(t.TempDir(), "roster.json")}
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(roster.Path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`[
+		{"youtube_id": "UC0000000000000000000008", "english_name": "Kept Person"},
+		{"youtube_id": "UC0000000000000000000009", "english_name": "Indie Person", "nicknames": ["Indie"]}
+	]`)
+
+	updater := vtubers.Updater{Store: store, Sources: []vtubers.TalentSource{roster}}
+	if err := updater.Update(ctx); err != nil {
+		t.Fatal(err)
+	}
+	latest, err := store.LatestUpdateID(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	write(`[{"youtube_id": "UC0000000000000000000008", "english_name": "Kept Person"}]`)
+	if err := updater.Update(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.FindByID(ctx, -1); err != nil {
+		t.Errorf("Expected -1 to be kept got %v", err)
+	}
+	if _, err := store.FindByID(ctx, -2); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected -2 to be removed got %v", err)
+	}
+	aliases, err := store.GetAliasesForVTuber(ctx, -2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(aliases) != 0 {
+		t.Errorf("Expected the aliases of -2 to be removed got %v", aliases)
+	}
+
+	changed, _, err := store.GetChangedSince(ctx, latest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(changed, []int{-2}) {
+		t.Errorf("Expected [-2] changed got %v", changed)
+	}
+}
+
+func TestUpdateKeepsVTubersOfEmptiedSource(t *testing.T) {
+	ctx := context.Background()
+	store := createTestStore(t)
+
+	listed := make(staticSource, 3)
+	for i := range listed {
+		listed[i].Key = strconv.Itoa(i + 1)
+		listed[i].ID = i + 1
+		listed[i].EnglishName = fmt.Sprintf("Person %d", i+1)
+	}
+	updater := vtubers.Updater{Store: store, Sources: []vtubers.TalentSource{listed}}
+	if err := updater.Update(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Such as a truncated response.
+	updater.Sources = []vtubers.TalentSource{listed[:1]}
+	if err := updater.Update(ctx); !errors.Is(err, vtubers.ErrTooFewRecords) {
+		t.Fatalf("Expected too few records got %v", err)
+	}
+	for id := 1; id <= 3; id++ {
+		if _, err := store.FindByID(ctx, id); err != nil {
+			t.Errorf("Expected %d to be kept got %v", id, err)
+		}
+	}
+
+	updater.Options.AllowRemovals = true
+	if err := updater.Update(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for id := 2; id <= 3; id++ {
+		if _, err := store.FindByID(ctx, id); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("Expected %d to be removed got %v", id, err)
+		}
+	}
+}
+
+func TestUpdateRemovesRecordsOfDroppedRoster(t *testing.T) {
+	ctx := context.Background()
+	store := createTestStore(t)
+
+	listed := staticSource{{
+		Key:            "1",
+		ID:             1,
+		VTuberRendered: vtubers.VTuberRendered{YouTubeID: "UC0000000000000000000001", EnglishName: "Usada Pekora"},
+	}}
+	roster := &vtubers.FileSource{Path: filepath.Join(t.TempDir(), "roster.json")}
+	err := os.WriteFile(roster.Path, []byte(`[
+		{"youtube_id": "UC0000000000000000000001", "hashtags": "#ぺこらいぶ"},
+		{"youtube_id": "UC0000000000000000000009", "english_name": "Indie Person"}
+	]`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	updater := vtubers.Updater{Store: store, Sources: []vtubers.TalentSource{listed, roster}}
+	if err := updater.Update(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	updater.Sources = []vtubers.TalentSource{listed}
+	if err := updater.Update(ctx); !errors.Is(err, vtubers.ErrTooFewRecords) {
+		t.Fatalf("Expected too few records got %v", err)
+	}
+	updater.Options.AllowRemovals = true
+	if err := updater.Update(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := store.FindByID(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Hashtags != "" {
+		t.Errorf("Expected the hashtags of the roster to be removed got %q", v.Hashtags)
+	}
+	if _, err := store.FindByID(ctx, -1); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected -1 to be removed got %v", err)
+	}
+	records, err := store.GetSourceRecords(ctx, roster.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 0 {
+		t.Errorf("Expected the records of the roster to be removed got %v", records)
+	}
+}
+
+func TestFileSourceCSV(t *testing.T) {
+	source := &vtubers.FileSource{Path: filepath.Join(t.TempDir(), "roster.csv")}
+	err := os.WriteFile(source.Path, []byte(
+		"youtube_id, english_name,nicknames,hashtags\n"+
+			"UC0000000000000000000009,Indie Person,Indie/ Person-chan ,＃indielive\n"+
+			",Channelless Person,,\n",
+	), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := source.Records(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records got %d", len(records))
+	}
+
+	indie := records[0]
+	if indie.Key != "UC0000000000000000000009" || indie.EnglishName != "Indie Person" || indie.Hashtags != "#indielive" {
+		t.Errorf("Unexpected record %+v", indie)
+	}
+	if !slices.Equal(indie.Nicknames, []string{"Indie", "Person-chan"}) {
+		t.Errorf("Expected nicknames [Indie Person-chan] got %q", indie.Nicknames)
+	}
+
+	channelless := records[1]
+	if channelless.Key != "Channelless Person" || channelless.YouTubeID != "" || len(channelless.Nicknames) != 0 {
+		t.Errorf("Unexpected record %+v", channelless)
+	}
+}